@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	adminToken = flag.String("adminToken", "", "Bearer token required by the mutating admin API endpoints")
+)
+
+func requireAdmin(action string, next http.HandlerFunc) http.HandlerFunc {
+	// Rejected requests are audited too
+	return audited(action, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Never accept requests when no token is configured
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || *adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+			logger.Warn("Rejected unauthorized admin request", zap.String("path", r.URL.Path), zap.String("remote", r.RemoteAddr))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Error encoding response", zap.Error(err))
+	}
+}
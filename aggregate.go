@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+const (
+	// Label identifying the exporter a merged series came from
+	dockerHostLabel = "docker_host"
+)
+
+var (
+	aggregateTargetUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_aggregate_target_up",
+			Help: "Whether the last scrape of the downstream exporter succeeded",
+		},
+		[]string{dockerHostLabel},
+	)
+	aggregateScrapeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_aggregate_scrape_duration_seconds",
+			Help: "Duration of the last scrape of the downstream exporter",
+		},
+		[]string{dockerHostLabel},
+	)
+)
+
+// aggregateTarget is a downstream exporter merged by the aggregator
+type aggregateTarget struct {
+	host string
+	url  string
+}
+
+// aggregateResult is the outcome of scraping one target
+type aggregateResult struct {
+	target   aggregateTarget
+	families map[string]*dto.MetricFamily
+	err      error
+}
+
+func runAggregate(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	port := fs.String("port", "8000", "Port to listen on for the merged metrics")
+	targetList := fs.String("targets", "", "Comma separated list of exporters to merge, as host:port, URL or name=URL")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout of each downstream scrape")
+	caFile := fs.String("caFile", "", "PEM file of extra CA certificates trusted for HTTPS targets, in addition to the host trust store")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	fs.Parse(args)
+
+	if err := os.Setenv("DEBUG", fmt.Sprintf("%t", *debug)); err != nil {
+		fmt.Printf("Error setting DEBUG env variable: %v", err)
+		os.Exit(1)
+	}
+	initLogger()
+	defer logger.Sync()
+
+	targets, err := parseAggregateTargets(*targetList)
+	if err != nil {
+		logger.Fatal("Error parsing aggregate targets", zap.Error(err))
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(aggregateTargetUp, aggregateScrapeDuration)
+	httpClient := &http.Client{Timeout: *timeout}
+	if *caFile != "" {
+		pool, err := caPool(*caFile)
+		if err != nil {
+			logger.Fatal("Error loading CA file", zap.Error(err))
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		httpClient.Transport = transport
+	}
+	// Targets first, so the scrape metrics are those of this scrape
+	gatherer := prometheus.Gatherers{
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return gatherTargets(httpClient, targets), nil
+		}),
+		registry,
+	}
+
+	// Keep serving what could be merged when a target is broken
+	http.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}))
+	logger.Info("Starting aggregator", zap.String("port", *port), zap.Int("targets", len(targets)))
+	if err := http.ListenAndServe(":"+*port, nil); err != nil {
+		logger.Fatal("Error starting HTTP server", zap.Error(err))
+	}
+}
+
+func parseAggregateTargets(list string) ([]aggregateTarget, error) {
+	var targets []aggregateTarget
+	seen := map[string]bool{}
+	for _, entry := range splitList(list) {
+		host, rawURL, named := strings.Cut(entry, "=")
+		if !named {
+			rawURL = entry
+		}
+		if !strings.Contains(rawURL, "://") {
+			rawURL = "http://" + rawURL + "/metrics"
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing target %q: %w", entry, err)
+		}
+		if !named {
+			host = u.Hostname()
+		}
+		if seen[host] {
+			return nil, fmt.Errorf("duplicate target host %q, name targets as name=URL", host)
+		}
+		seen[host] = true
+		targets = append(targets, aggregateTarget{host: host, url: u.String()})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets to aggregate")
+	}
+	return targets, nil
+}
+
+func gatherTargets(httpClient *http.Client, targets []aggregateTarget) []*dto.MetricFamily {
+	// Scrape all targets at once so one slow host doesn't delay the others
+	results := make([]aggregateResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			families, err := scrapeTarget(httpClient, target.url)
+			aggregateScrapeDuration.WithLabelValues(target.host).Set(time.Since(start).Seconds())
+			results[i] = aggregateResult{target: target, families: families, err: err}
+		}()
+	}
+	wg.Wait()
+
+	merged := map[string]*dto.MetricFamily{}
+	for _, result := range results {
+		if result.err != nil {
+			logger.Error("Error scraping target", zap.String("host", result.target.host), zap.Error(result.err))
+			aggregateTargetUp.WithLabelValues(result.target.host).Set(0)
+			continue
+		}
+		aggregateTargetUp.WithLabelValues(result.target.host).Set(1)
+
+		for name, family := range result.families {
+			for _, metric := range family.Metric {
+				addHostLabel(metric, result.target.host)
+			}
+			existing, ok := merged[name]
+			if !ok {
+				merged[name] = family
+				continue
+			}
+			if existing.GetType() != family.GetType() {
+				logger.Warn("Skipping metric with a type differing between targets", zap.String("host", result.target.host), zap.String("metric", name))
+				continue
+			}
+			existing.Metric = append(existing.Metric, family.Metric...)
+		}
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(merged))
+	for _, family := range merged {
+		families = append(families, family)
+	}
+	return families
+}
+
+func scrapeTarget(httpClient *http.Client, targetURL string) (map[string]*dto.MetricFamily, error) {
+	resp, err := httpClient.Get(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("error scraping %s: %w", targetURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error scraping %s: %s", targetURL, resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metrics of %s: %w", targetURL, err)
+	}
+	return families, nil
+}
+
+func addHostLabel(metric *dto.Metric, host string) {
+	// Series from nested aggregators already name their host
+	for _, label := range metric.Label {
+		if label.GetName() == dockerHostLabel {
+			return
+		}
+	}
+	name := dockerHostLabel
+	metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &host})
+	sort.Slice(metric.Label, func(i, j int) bool {
+		return metric.Label[i].GetName() < metric.Label[j].GetName()
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const (
+	teamLabel        = "team"
+	maintenanceLabel = "maintenance"
+)
+
+var (
+	alertTeamLabel     = flag.String("alertTeamLabel", "docker-prom.alert-team", "Container label whose value is added as a team label to all series of the container, for alert routing (disabled when empty)")
+	silenceLabel       = flag.String("silenceLabel", "docker-prom.silence", "Container label that, set to true, adds maintenance=\"true\" to all series of the container (disabled when empty)")
+	maintenanceEnabled = flag.Bool("maintenanceAPI", false, "Enable POST /api/v1/maintenance to put the whole host in maintenance, adding maintenance=\"true\" to every series (requires adminToken)")
+
+	// Alert routing labels by container name, refreshed every collection
+	alertLabelsMu sync.RWMutex
+	alertLabels   = map[string]map[string]string{}
+
+	// Host-wide maintenance set through the API, with no end when until is zero
+	maintenanceMu    sync.RWMutex
+	maintenanceOn    bool
+	maintenanceUntil time.Time
+
+	hostMaintenance = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_maintenance",
+			Help: "Whether the host is in maintenance through the maintenance API (1) or not (0)",
+		},
+		func() float64 {
+			if inMaintenance(time.Now()) {
+				return 1
+			}
+			return 0
+		},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(hostMaintenance)
+}
+
+// maintenanceResponse is the maintenance state after a request
+type maintenanceResponse struct {
+	Maintenance bool       `json:"maintenance"`
+	Until       *time.Time `json:"until,omitempty"`
+}
+
+func refreshAlertLabels(ctx context.Context, cli *client.Client) {
+	if *alertTeamLabel == "" && *silenceLabel == "" {
+		return
+	}
+	// Stopped containers too, their exits and runs are reported
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{All: true})
+	if err != nil {
+		logger.Error("Error listing containers for alert labels", zap.Error(err))
+		return
+	}
+	refreshed := map[string]map[string]string{}
+	for _, container := range containers {
+		labels := map[string]string{}
+		if team := container.Labels[*alertTeamLabel]; *alertTeamLabel != "" && team != "" {
+			labels[teamLabel] = team
+		}
+		if silenced, _ := strconv.ParseBool(container.Labels[*silenceLabel]); *silenceLabel != "" && silenced {
+			labels[maintenanceLabel] = "true"
+		}
+		if len(labels) > 0 {
+			refreshed[container.Names[0]] = labels
+		}
+	}
+
+	alertLabelsMu.Lock()
+	defer alertLabelsMu.Unlock()
+	alertLabels = refreshed
+}
+
+func addAlertLabels(families []*dto.MetricFamily) {
+	alertLabelsMu.RLock()
+	defer alertLabelsMu.RUnlock()
+	maintenance := inMaintenance(time.Now())
+	if len(alertLabels) == 0 && !maintenance {
+		return
+	}
+
+	// Only container series have an owner, host maintenance covers every series
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			containerName := ""
+			have := make(map[string]bool, len(m.GetLabel()))
+			for _, label := range m.GetLabel() {
+				have[label.GetName()] = true
+				if label.GetName() == "container_name" {
+					containerName = label.GetValue()
+				}
+			}
+			labels := alertLabels[containerName]
+			if maintenance {
+				labels = withHostMaintenance(labels)
+			}
+			if len(labels) == 0 {
+				continue
+			}
+			// Labels of the series win over the container's
+			for name, value := range labels {
+				if !have[name] {
+					m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+				}
+			}
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+}
+
+func withHostMaintenance(labels map[string]string) map[string]string {
+	// The refreshed labels are shared by scrapes, copy them
+	merged := make(map[string]string, len(labels)+1)
+	for name, value := range labels {
+		merged[name] = value
+	}
+	merged[maintenanceLabel] = "true"
+	return merged
+}
+
+func inMaintenance(now time.Time) bool {
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+	return maintenanceOn && (maintenanceUntil.IsZero() || now.Before(maintenanceUntil))
+}
+
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	enabled, err := strconv.ParseBool(query.Get("enabled"))
+	if err != nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	// Maintenance ends by itself after the duration, or when turned off
+	var until time.Time
+	if duration := query.Get("duration"); duration != "" && enabled {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			http.Error(w, "duration must be a positive duration like 2h", http.StatusBadRequest)
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	maintenanceMu.Lock()
+	maintenanceOn, maintenanceUntil = enabled, until
+	maintenanceMu.Unlock()
+
+	logger.Info("Host maintenance changed", zap.Bool("enabled", enabled), zap.Time("until", until), zap.String("remote", r.RemoteAddr))
+	response := maintenanceResponse{Maintenance: enabled}
+	if !until.IsZero() {
+		response.Until = &until
+	}
+	writeJSON(w, http.StatusOK, response)
+}
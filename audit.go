@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	outcomeSuccess      = "success"
+	outcomeFailure      = "failure"
+	outcomeUnauthorized = "unauthorized"
+
+	// Bytes of the token hash recorded, enough to tell tokens apart
+	tokenFingerprintSize = 8
+)
+
+var (
+	auditLogPath = flag.String("auditLog", "", "Append-only file the admin API requests are recorded to as JSON lines, required by the prune, restart and maintenance APIs")
+
+	adminActions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prom_admin_actions_total",
+			Help: "Number of admin API requests by action and outcome",
+		},
+		[]string{"action", "outcome"},
+	)
+
+	auditMu   sync.Mutex
+	auditFile *os.File
+)
+
+func init() {
+	exporterRegistry.MustRegister(adminActions)
+}
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	Status    int       `json:"status"`
+	Remote    string    `json:"remote"`
+	Token     string    `json:"token,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Query     string    `json:"query,omitempty"`
+	Duration  float64   `json:"durationSeconds"`
+}
+
+func openAuditLog() error {
+	// Never truncated nor rewritten, rotation is left to logrotate copytruncate
+	f, err := os.OpenFile(*auditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	auditFile = f
+	return nil
+}
+
+// statusRecorder keeps the status written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		outcome := outcomeSuccess
+		switch {
+		case recorder.status == http.StatusUnauthorized:
+			outcome = outcomeUnauthorized
+		case recorder.status >= http.StatusBadRequest:
+			outcome = outcomeFailure
+		}
+		adminActions.WithLabelValues(action, outcome).Inc()
+		writeAudit(auditEntry{
+			Time:      start.UTC(),
+			Action:    action,
+			Outcome:   outcome,
+			Status:    recorder.status,
+			Remote:    r.RemoteAddr,
+			Token:     tokenFingerprint(r),
+			UserAgent: r.UserAgent(),
+			Query:     r.URL.RawQuery,
+			Duration:  time.Since(start).Seconds(),
+		})
+	}
+}
+
+// tokenFingerprint identifies the bearer token of the request without
+// revealing it, the same token always has the same fingerprint
+func tokenFingerprint(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:tokenFingerprintSize])
+}
+
+func writeAudit(entry auditEntry) {
+	logger.Info("Admin action", zap.String("action", entry.Action), zap.String("outcome", entry.Outcome), zap.Int("status", entry.Status), zap.String("remote", entry.Remote), zap.String("token", entry.Token), zap.String("query", entry.Query))
+	if auditFile == nil {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("Error encoding audit entry", zap.Error(err))
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	// One write per entry, appends of concurrent requests don't interleave
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		logger.Error("Error writing audit log", zap.String("path", *auditLogPath), zap.Error(err))
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+const (
+	// API version spoken by the fake daemon
+	benchAPIVersion = "1.47"
+	// Containers share this many images, like a host running replicas
+	benchImages = 20
+)
+
+var (
+	benchVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+)
+
+// benchResult is the cost of a collection cycle, averaged over the cycles run
+type benchResult struct {
+	cycles   int
+	duration time.Duration
+	allocs   uint64
+	bytes    uint64
+}
+
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	containerCounts := fs.String("containers", "1000,5000", "Comma separated list of container counts to benchmark")
+	collectorNames := fs.String("collectors", "image,stats,config", "Comma separated list of collectors to benchmark")
+	benchTime := fs.Duration("benchtime", time.Second, "Minimum run time of each benchmark")
+	fs.Parse(args)
+
+	// Errors of the collectors would only slow the benchmark down
+	logger = zap.NewNop()
+
+	enabled, err := enabledCollectors(*collectorNames)
+	if err != nil {
+		fmt.Printf("Error enabling collectors: %v\n", err)
+		os.Exit(1)
+	}
+	var counts []int
+	for _, count := range splitList(*containerCounts) {
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			fmt.Printf("Invalid container count %q\n", count)
+			os.Exit(1)
+		}
+		counts = append(counts, n)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINERS\tCYCLES\tCYCLE DURATION\tALLOCS/CYCLE\tBYTES/CYCLE")
+	for _, n := range counts {
+		result, err := benchCollection(enabled, n, *benchTime)
+		if err != nil {
+			fmt.Printf("Error benchmarking %d containers: %v\n", n, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%d\n", n, result.cycles, result.duration, result.allocs, result.bytes)
+	}
+	w.Flush()
+}
+
+func benchCollection(enabled []*collector, containers int, benchTime time.Duration) (benchResult, error) {
+	cli, stop, err := newBenchClient(containers)
+	if err != nil {
+		return benchResult{}, err
+	}
+	defer stop()
+
+	gatherer := newGatherer(enabled, false)
+	ctx := context.Background()
+	// Warm up caches first, like the steady state of a running exporter
+	if err := benchCycle(ctx, cli, enabled, gatherer); err != nil {
+		return benchResult{}, err
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	cycles := 0
+	for cycles == 0 || time.Since(start) < benchTime {
+		if err := benchCycle(ctx, cli, enabled, gatherer); err != nil {
+			return benchResult{}, err
+		}
+		cycles++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	// The fake daemon allocates in the same process, as in go test -bench
+	return benchResult{
+		cycles:   cycles,
+		duration: elapsed / time.Duration(cycles),
+		allocs:   (after.Mallocs - before.Mallocs) / uint64(cycles),
+		bytes:    (after.TotalAlloc - before.TotalAlloc) / uint64(cycles),
+	}, nil
+}
+
+// newBenchClient returns a client of a fake daemon with the containers, and
+// a func stopping both
+func newBenchClient(containers int) (*client.Client, func(), error) {
+	// Not httptest, which would link the testing package into the exporter
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listening for the fake daemon: %w", err)
+	}
+	daemon := &http.Server{Handler: newFakeDaemon(containers)}
+	go daemon.Serve(listener)
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+listener.Addr().String()), client.WithVersion(benchAPIVersion))
+	if err != nil {
+		daemon.Close()
+		return nil, nil, fmt.Errorf("error creating Docker client: %w", err)
+	}
+	return cli, func() {
+		cli.Close()
+		daemon.Close()
+	}, nil
+}
+
+// benchCycle is a collection plus encoding the output, as for a scrape
+func benchCycle(ctx context.Context, cli *client.Client, enabled []*collector, gatherer prometheus.Gatherer) error {
+	collectDockerMetrics(ctx, cli, enabled)
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+	encoder := expfmt.NewEncoder(io.Discard, PromText)
+	for _, mf := range families {
+		if err := encoder.Encode(mf); err != nil {
+			return fmt.Errorf("error encoding metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// newFakeDaemon serves the Docker API requests of the collectors from canned
+// responses, so only the exporter is measured
+func newFakeDaemon(containers int) http.Handler {
+	list := make([]types.Container, containers)
+	inspects := map[string][]byte{}
+	stats := map[string][]byte{}
+	for i := range list {
+		id := fmt.Sprintf("%064x", i+1)
+		imageID := benchImageID(i % benchImages)
+		list[i] = types.Container{
+			ID:      id,
+			Names:   []string{fmt.Sprintf("/bench-%d", i)},
+			Image:   benchImageRepo(i % benchImages),
+			ImageID: imageID,
+			State:   "running",
+			Labels:  map[string]string{"com.example.bench": "true"},
+		}
+		inspects[id] = benchJSON(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         id,
+				Name:       list[i].Names[0],
+				Image:      imageID,
+				State:      &types.ContainerState{Status: "running", Running: true},
+				HostConfig: &typeContainer.HostConfig{},
+			},
+			Config: &typeContainer.Config{
+				Hostname: id[:12],
+				Image:    list[i].Image,
+				Env:      []string{"PATH=/usr/local/bin:/usr/bin:/bin"},
+				Labels:   list[i].Labels,
+			},
+			NetworkSettings: &types.NetworkSettings{},
+		})
+		stats[id] = benchJSON(typeContainer.StatsResponse{
+			Networks: map[string]typeContainer.NetworkStats{
+				"eth0": {RxBytes: uint64(i) * 1024, RxPackets: uint64(i), TxBytes: uint64(i) * 512, TxPackets: uint64(i)},
+			},
+		})
+	}
+
+	images := make([]image.Summary, benchImages)
+	imageInspects := map[string][]byte{}
+	for i := range images {
+		inspect := benchJSON(types.ImageInspect{
+			ID:           benchImageID(i),
+			RepoTags:     []string{benchImageRepo(i)},
+			Architecture: "amd64",
+			Os:           "linux",
+			Config:       &typeContainer.Config{Env: []string{"PATH=/usr/local/bin:/usr/bin:/bin"}},
+			RootFS:       types.RootFS{Type: "layers", Layers: []string{benchImageID(i)}},
+		})
+		images[i] = image.Summary{ID: benchImageID(i), RepoTags: []string{benchImageRepo(i)}}
+		// Images are inspected by ID as well as by reference
+		imageInspects[benchImageID(i)] = inspect
+		imageInspects[benchImageRepo(i)] = inspect
+	}
+
+	listBody := benchJSON(list)
+	imagesBody := benchJSON(images)
+	infoBody := benchJSON(system.Info{Architecture: "x86_64", OSType: "linux"})
+	networksBody := benchJSON([]network.Summary{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := benchVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		var body []byte
+		switch {
+		case path == "/containers/json":
+			body = listBody
+		case path == "/images/json":
+			body = imagesBody
+		case path == "/info":
+			body = infoBody
+		case path == "/networks":
+			body = networksBody
+		case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+			body = inspects[strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")]
+		case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/stats"):
+			body = stats[strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/stats")]
+		case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+			body = imageInspects[strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if body == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"message":"no such object: %s"}`, path)
+			return
+		}
+		w.Write(body)
+	})
+}
+
+func benchImageID(i int) string {
+	return fmt.Sprintf("sha256:%064x", 0xbe00+i)
+}
+
+func benchImageRepo(i int) string {
+	return fmt.Sprintf("registry.example.com/bench/app-%d:latest", i)
+}
+
+func benchJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
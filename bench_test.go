@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func BenchmarkCollect1k(b *testing.B) {
+	benchmarkCollect(b, 1000)
+}
+
+func BenchmarkCollect5k(b *testing.B) {
+	benchmarkCollect(b, 5000)
+}
+
+func benchmarkCollect(b *testing.B, containers int) {
+	// Errors of the collectors would only slow the benchmark down
+	logger = zap.NewNop()
+
+	enabled, err := enabledCollectors("image,stats,config")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cli, stop, err := newBenchClient(containers)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stop()
+
+	gatherer := newGatherer(enabled, false)
+	ctx := context.Background()
+	if err := benchCycle(ctx, cli, enabled, gatherer); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := benchCycle(ctx, cli, enabled, gatherer); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
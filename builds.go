@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// How often running build steps are sampled
+	buildPollInterval = time.Second
+)
+
+var (
+	buildStepsInProgress = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_build_steps_in_progress",
+			Help: "Number of BuildKit build steps currently running on the daemon",
+		},
+	)
+	buildStepDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "docker_build_step_duration_seconds",
+			Help:    "Duration of finished BuildKit build steps, to the poll interval",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		},
+	)
+	buildSteps = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "docker_build_steps_total",
+			Help: "Number of finished BuildKit build steps",
+		},
+	)
+
+	// Running build steps by bundle directory -> first seen
+	buildStepsMu     sync.Mutex
+	buildStepsActive = map[string]time.Time{}
+)
+
+func init() {
+	c := registerCollector("builds", nil, buildStepsInProgress, buildStepDuration, buildSteps)
+	c.start = trackBuildSteps
+	c.hostFiles = true
+	c.dumpState = func() map[string]any {
+		buildStepsMu.Lock()
+		defer buildStepsMu.Unlock()
+		return map[string]any{"steps": len(buildStepsActive)}
+	}
+}
+
+func trackBuildSteps(ctx context.Context, _ *client.Client) {
+	// The Engine API has no view of BuildKit builds and the events stream has
+	// none either, but every RUN step gets an OCI bundle directory under the
+	// executor root of the daemon's BuildKit worker while it runs
+	executorDir := filepath.Join(*dockerRoot, "buildkit", "executor")
+	if _, err := os.Stat(executorDir); err != nil {
+		logger.Warn("BuildKit executor directory not accessible, build steps won't be tracked", zap.String("path", executorDir), zap.Error(err))
+		return
+	}
+
+	pollInterval := buildPollInterval
+	if *lowPower {
+		pollInterval = lowPowerPullPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		entries, err := os.ReadDir(executorDir)
+		if err != nil {
+			logger.Error("Error listing running build steps", zap.Error(err))
+		}
+		var bundles []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				bundles = append(bundles, entry.Name())
+			}
+		}
+		updateBuildSteps(bundles, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func updateBuildSteps(bundles []string, now time.Time) {
+	buildStepsMu.Lock()
+	defer buildStepsMu.Unlock()
+
+	active := make(map[string]time.Time, len(bundles))
+	for _, bundle := range bundles {
+		if seen, ok := buildStepsActive[bundle]; ok {
+			active[bundle] = seen
+		} else {
+			active[bundle] = now
+		}
+	}
+
+	// Steps that went away finished, whatever their outcome
+	for bundle, seen := range buildStepsActive {
+		if _, ok := active[bundle]; ok {
+			continue
+		}
+		buildStepDuration.Observe(now.Sub(seen).Seconds())
+		buildSteps.Inc()
+	}
+	buildStepsActive = active
+	buildStepsInProgress.Set(float64(len(active)))
+}
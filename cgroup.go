@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	// Pressure stall information of cgroup v2, the share of time tasks of
+	// the container waited on a resource
+	containerPressure = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_pressure_percent",
+			Help: "Percentage of time some or all tasks of the container were stalled on the resource, averaged over the window",
+		},
+		[]string{"container_name", "resource", "kind", "window"},
+	)
+	containerPressureStalled = newCounterSeries("docker_container_pressure_stalled_seconds_total", "Total time some or all tasks of the container were stalled on the resource", "container_name", "resource", "kind")
+
+	// Memory the kernel uses on behalf of the container, which a leak can
+	// grow without any change in user memory
+	containerKernelMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_kernel_bytes",
+			Help: "Kernel memory charged to the container cgroup, including slab, stacks, page tables and socket buffers",
+		},
+		[]string{"container_name"},
+	)
+	containerSlabMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_slab_bytes",
+			Help: "Slab memory charged to the container cgroup, reclaimable or not",
+		},
+		[]string{"container_name", "state"},
+	)
+	containerSwap = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_swap_bytes",
+			Help: "Swap used by the container cgroup",
+		},
+		[]string{"container_name"},
+	)
+
+	// Where the memory of the container resides on multi-socket hosts
+	containerNUMAMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_numa_bytes",
+			Help: "Memory of the container cgroup on the NUMA node, by type",
+		},
+		[]string{"container_name", "node", "type"},
+	)
+
+	cgroupGauges = []*prometheus.GaugeVec{containerPressure, containerKernelMemory, containerSlabMemory, containerSwap, containerNUMAMemory}
+
+	// memory.stat keys making up kernel memory on kernels before 5.18, which
+	// have no kernel key
+	kernelMemoryKeys = []string{"kernel_stack", "pagetables", "percpu", "sock", "vmalloc", "slab"}
+
+	// memory.numa_stat has a line per type of memory, most of them small,
+	// anonymous and page cache memory are what cross-node access is about
+	numaMemoryTypes = map[string]bool{"anon": true, "file": true}
+
+	// Resources with a pressure file in the cgroup directory
+	pressureResources = []string{"cpu", "memory", "io"}
+
+	// Containers of cgroup v1 hosts have no unified hierarchy to read from
+	errCgroupV1 = errors.New("not a cgroup v2 container")
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range cgroupGauges {
+		metrics = append(metrics, g)
+	}
+	metrics = append(metrics, containerPressureStalled)
+	c := registerCollector("cgroup", collectCgroupMetrics, metrics...)
+	c.hostFiles = true
+}
+
+func collectCgroupMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range cgroupGauges {
+		g.Reset()
+	}
+	containerPressureStalled.Reset()
+
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	for _, container := range containers {
+		containerName := container.Names[0]
+
+		// The pid is only available from the full inspect
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+		if info.State == nil || info.State.Pid == 0 {
+			continue
+		}
+
+		dir, err := containerCgroupDir(info.State.Pid)
+		if errors.Is(err, errCgroupV1) {
+			logger.Debug("Skipping cgroup metrics", zap.String("containerName", containerName), zap.Error(err))
+			continue
+		}
+		if err != nil {
+			logger.Error("Error finding container cgroup", zap.String("containerName", containerName), zap.Error(err))
+			continue
+		}
+
+		for _, resource := range pressureResources {
+			if err := setPressureMetrics(containerName, dir, resource); err != nil {
+				logger.Error("Error reading pressure stall information", zap.String("containerName", containerName), zap.String("resource", resource), zap.Error(err))
+			}
+		}
+		if err := setKernelMemoryMetrics(containerName, dir); err != nil {
+			logger.Error("Error reading kernel memory usage", zap.String("containerName", containerName), zap.Error(err))
+		}
+		if err := setNUMAMetrics(containerName, dir); err != nil {
+			logger.Error("Error reading NUMA memory placement", zap.String("containerName", containerName), zap.Error(err))
+		}
+	}
+}
+
+func containerCgroupDir(pid int) (string, error) {
+	// The cgroup of the init process, the exporter needs the host cgroup
+	// namespace for the path to be relative to the host hierarchy
+	data, err := os.ReadFile(hostPath("/proc/" + strconv.Itoa(pid) + "/cgroup"))
+	if err != nil {
+		return "", fmt.Errorf("error reading cgroup: %w", err)
+	}
+
+	// The unified hierarchy is the only one with ID 0 and no controllers
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return hostPath("/sys/fs/cgroup" + path), nil
+		}
+	}
+	return "", errCgroupV1
+}
+
+func setPressureMetrics(containerName, dir, resource string) error {
+	file, err := os.Open(dir + "/" + resource + ".pressure")
+	if errors.Is(err, fs.ErrNotExist) {
+		// Kernels built without PSI or booted with psi=0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening %s.pressure: %w", resource, err)
+	}
+	defer file.Close()
+
+	// Lines are like some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		kind := fields[0]
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if key == "total" {
+				// Microseconds
+				total, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return fmt.Errorf("error parsing %s.pressure total: %w", resource, err)
+				}
+				containerPressureStalled.set(float64(total)/1e6, []string{containerName, resource, kind})
+				continue
+			}
+			window, ok := strings.CutPrefix(key, "avg")
+			if !ok {
+				continue
+			}
+			percent, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing %s.pressure %s: %w", resource, key, err)
+			}
+			containerPressure.WithLabelValues(containerName, resource, kind, window+"s").Set(percent)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s.pressure: %w", resource, err)
+	}
+	return nil
+}
+
+func setKernelMemoryMetrics(containerName, dir string) error {
+	stat, err := readCgroupStat(dir + "/memory.stat")
+	if errors.Is(err, fs.ErrNotExist) {
+		// The memory controller isn't enabled for the cgroup
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	kernel, ok := stat["kernel"]
+	if !ok {
+		for _, key := range kernelMemoryKeys {
+			kernel += stat[key]
+		}
+	}
+	containerKernelMemory.WithLabelValues(containerName).Set(float64(kernel))
+	if reclaimable, ok := stat["slab_reclaimable"]; ok {
+		containerSlabMemory.WithLabelValues(containerName, "reclaimable").Set(float64(reclaimable))
+	}
+	if unreclaimable, ok := stat["slab_unreclaimable"]; ok {
+		containerSlabMemory.WithLabelValues(containerName, "unreclaimable").Set(float64(unreclaimable))
+	}
+
+	// Missing when swap accounting is off
+	swap, err := os.ReadFile(dir + "/memory.swap.current")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading memory.swap.current: %w", err)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(swap)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("error parsing memory.swap.current: %w", err)
+	}
+	containerSwap.WithLabelValues(containerName).Set(float64(value))
+	return nil
+}
+
+func setNUMAMetrics(containerName, dir string) error {
+	data, err := os.ReadFile(dir + "/memory.numa_stat")
+	if errors.Is(err, fs.ErrNotExist) {
+		// Kernels built without NUMA support
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading memory.numa_stat: %w", err)
+	}
+
+	// Lines are like anon N0=1024 N1=0, in bytes
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !numaMemoryTypes[fields[0]] {
+			continue
+		}
+		for _, field := range fields[1:] {
+			node, value, ok := strings.Cut(strings.TrimPrefix(field, "N"), "=")
+			if !ok {
+				continue
+			}
+			bytes, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("error parsing memory.numa_stat %s: %w", fields[0], err)
+			}
+			containerNUMAMemory.WithLabelValues(containerName, node, fields[0]).Set(float64(bytes))
+		}
+	}
+	return nil
+}
+
+func readCgroupStat(path string) (map[string]uint64, error) {
+	// Flat keyed files, one key and value per line
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	stat := map[string]uint64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s of %s: %w", key, path, err)
+		}
+		stat[key] = n
+	}
+	return stat, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types/checkpoint"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	// CRIU checkpoints of containers, created by docker checkpoint create on
+	// daemons with experimental features
+	containerCheckpoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_checkpoints",
+			Help: "Number of checkpoints of the container in the default checkpoint directory",
+		},
+		[]string{"container_name"},
+	)
+	containerCheckpointAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_checkpoint_age_seconds",
+			Help: "Time since the checkpoint of the container was created",
+		},
+		[]string{"container_name", "checkpoint"},
+	)
+
+	checkpointGauges = []*prometheus.GaugeVec{containerCheckpoints, containerCheckpointAge}
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range checkpointGauges {
+		metrics = append(metrics, g)
+	}
+	c := registerCollector("checkpoints", collectCheckpointMetrics, metrics...)
+	c.hostFiles = true
+}
+
+func collectCheckpointMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range checkpointGauges {
+		g.Reset()
+	}
+
+	// Checkpoints are kept after the container stops, that's when they're restored
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{All: true})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	for _, container := range containers {
+		containerName := container.Names[0]
+		checkpoints, err := cli.CheckpointList(ctx, container.ID, checkpoint.ListOptions{})
+		if errdefs.IsNotImplemented(err) {
+			// Not an experimental daemon, none of the containers have any
+			logger.Debug("Checkpoints not supported by the daemon", zap.Error(err))
+			return
+		}
+		if err != nil {
+			logger.Error("Error listing checkpoints", zap.String("containerName", containerName), zap.Error(err))
+			continue
+		}
+		containerCheckpoints.WithLabelValues(containerName).Set(float64(len(checkpoints)))
+		if len(checkpoints) == 0 {
+			continue
+		}
+
+		// The API has no creation time, checkpoints are directories in the
+		// checkpoints directory of the container, next to its resolv.conf
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+		if info.ResolvConfPath == "" {
+			continue
+		}
+		checkpointDir := filepath.Join(filepath.Dir(info.ResolvConfPath), "checkpoints")
+		for _, c := range checkpoints {
+			stat, err := os.Stat(hostPath(filepath.Join(checkpointDir, c.Name)))
+			if err != nil {
+				logger.Debug("Error getting checkpoint creation time", zap.String("containerName", containerName), zap.String("checkpoint", c.Name), zap.Error(err))
+				continue
+			}
+			containerCheckpointAge.WithLabelValues(containerName, c.Name).Set(now.Sub(stat.ModTime()).Seconds())
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// collector groups related metrics behind their own registry so they can be
+// enabled, gathered and written out independently
+type collector struct {
+	name     string
+	registry *prometheus.Registry
+	metrics  []prometheus.Collector
+
+	// Called every collection cycle, may be nil
+	collect func(ctx context.Context, cli *client.Client)
+	// Started once in the background when the collector is enabled, may be nil
+	start func(ctx context.Context, cli *client.Client)
+	// Called for every Docker event when the collector is enabled, may be nil
+	handleEvent func(msg events.Message)
+	// Reports internal state for the SIGUSR1 dump, may be nil
+	dumpState func() map[string]any
+	// Drops cached data when the exporter nears its memory limit, may be nil
+	shrink func()
+	// Reads files of the host, only collected from daemons running on it
+	hostFiles bool
+	// Keeps state of one daemon across cycles, only collected from the first
+	primaryOnly bool
+
+	// Unix nanoseconds and duration of the last collection, for the state dump
+	lastCollected atomic.Int64
+	lastDuration  atomic.Int64
+}
+
+const (
+	// Per-container collection stages that can fail
+	stageInspect      = "inspect"
+	stageImageInspect = "image_inspect"
+	stageStats        = "stats"
+)
+
+var (
+	collectorTimeout = flag.Duration("collectorTimeout", 0, "Maximum time each collector may take per collection, Docker calls still running are cancelled (0 for no limit)")
+
+	// All known collectors, in registration order
+	allCollectors []*collector
+
+	containerCollectionError = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_collection_error",
+			Help: "Set when a collection stage failed for the container in the last cycle, its metrics are partial",
+		},
+		[]string{"container_name", "stage"},
+	)
+	collectorTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prom_collector_timeouts_total",
+			Help: "Number of collections cut short by the collector timeout, their metrics are partial",
+		},
+		[]string{"collector"},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(collectorTimeouts)
+	collectionRegistry.MustRegister(containerCollectionError)
+}
+
+func registerCollector(name string, collect func(context.Context, *client.Client), metrics ...prometheus.Collector) *collector {
+	c := &collector{
+		name:     name,
+		registry: prometheus.NewRegistry(),
+		metrics:  metrics,
+		collect:  collect,
+	}
+	c.registry.MustRegister(metrics...)
+	allCollectors = append(allCollectors, c)
+	return c
+}
+
+// shared reports whether the collector runs against every daemon, it keeps no
+// state of a single daemon across cycles
+func (c *collector) shared() bool {
+	return c.collect != nil && c.handleEvent == nil && c.start == nil && !c.primaryOnly
+}
+
+// resetMetrics drops the series of the collector, for collectors returning
+// early not to leave those of the last daemon collected behind
+func (c *collector) resetMetrics() {
+	for _, m := range c.metrics {
+		if vec, ok := m.(interface{ Reset() }); ok {
+			vec.Reset()
+		}
+	}
+}
+
+func findCollector(name string) *collector {
+	for _, c := range allCollectors {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func collectorNames() []string {
+	names := make([]string, 0, len(allCollectors))
+	for _, c := range allCollectors {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func enabledCollectors(names string) ([]*collector, error) {
+	var enabled []*collector
+	seen := map[string]bool{}
+	for _, name := range splitList(names) {
+		if seen[name] {
+			continue
+		}
+		c := findCollector(name)
+		if c == nil {
+			return nil, fmt.Errorf("unknown collector %q, available: %s", name, strings.Join(collectorNames(), ","))
+		}
+		seen[name] = true
+		enabled = append(enabled, c)
+	}
+	if *enableStats && !seen["stats"] {
+		enabled = append(enabled, findCollector("stats"))
+	}
+	return enabled, nil
+}
+
+func collectorEnabled(enabled []*collector, name string) bool {
+	for _, c := range enabled {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func startCollectors(ctx context.Context, cli *client.Client, enabled []*collector) {
+	var watchers []*collector
+	for _, c := range enabled {
+		if c.start != nil {
+			logger.Debug("Starting collector", zap.String("collector", c.name))
+			go c.start(ctx, cli)
+		}
+		if c.handleEvent != nil {
+			watchers = append(watchers, c)
+		}
+	}
+
+	// A single event stream is shared by all collectors interested in events
+	if len(watchers) > 0 {
+		go watchEvents(ctx, cli, watchers)
+	}
+}
+
+func collectDockerMetrics(ctx context.Context, cli *client.Client, enabled []*collector) {
+	// Clear old metrics to avoid duplicates
+	containerCollectionError.Reset()
+	resetContainerList()
+	resetStatsCache()
+	refreshTenants(ctx, cli)
+	refreshAlertLabels(ctx, cli)
+	refreshHostMetadata()
+
+	for _, c := range enabled {
+		if c.collect == nil {
+			continue
+		}
+		logger.Debug("Running collector", zap.String("collector", c.name))
+		start := time.Now()
+		runCollector(ctx, cli, c)
+		c.lastCollected.Store(start.UnixNano())
+		c.lastDuration.Store(int64(time.Since(start)))
+	}
+}
+
+func runCollector(ctx context.Context, cli *client.Client, c *collector) {
+	if *collectorTimeout <= 0 {
+		c.collect(ctx, cli)
+		return
+	}
+	// A slow daemon endpoint only holds up its own collector
+	collectCtx, cancel := context.WithTimeout(ctx, *collectorTimeout)
+	defer cancel()
+	c.collect(collectCtx, cli)
+	if errors.Is(collectCtx.Err(), context.DeadlineExceeded) {
+		logger.Warn("Collector timed out, its metrics are partial", zap.String("collector", c.name), zap.Duration("timeout", *collectorTimeout))
+		collectorTimeouts.WithLabelValues(c.name).Inc()
+	}
+}
+
+func setCollectionError(containerName, stage string, err error) {
+	// Containers removed since they were listed are gone, not partial
+	if errdefs.IsNotFound(err) {
+		return
+	}
+	containerCollectionError.WithLabelValues(containerName, stage).Set(1)
+}
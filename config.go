@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configFile = flag.String("config", "", "YAML file of flag values keyed by flag name, flags on the command line take precedence")
+
+	// Where each flag took its value from, recorded at startup as previews set flags too
+	configFileFlags = map[string]bool{}
+	flagSources     = map[string]string{}
+
+	// Only the braced form is expanded, a lone $ in a value stays as is
+	configEnvVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+const (
+	// Suffix of settings read from a file, as in adminToken_file
+	configFileSuffix = "_file"
+)
+
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	values, err := parseConfig(data, true)
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	// Command line flags override the file
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, value := range values {
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("error setting %s from config file: %w", name, err)
+		}
+		configFileFlags[name] = true
+	}
+	return nil
+}
+
+// parseConfig reads flag values from YAML. With secrets, values can reference
+// environment variables as ${VAR} and settings can be read from a file with
+// the _file suffix, so secrets don't have to be inlined.
+func parseConfig(data []byte, secrets bool) (map[string]string, error) {
+	// Nodes keep the values as written, 0644 stays octal
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	files := map[string]string{}
+	for name, node := range nodes {
+		if base, ok := strings.CutSuffix(name, configFileSuffix); ok && secrets && flag.Lookup(base) != nil {
+			if node.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("setting %q must be a file path", name)
+			}
+			files[base] = node.Value
+			continue
+		}
+		if name == "config" || flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting %q", name)
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			values[name] = node.Value
+		case yaml.SequenceNode:
+			// Lists are comma separated on the command line
+			var items []string
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("setting %q must be a list of values", name)
+				}
+				items = append(items, item.Value)
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("setting %q must be a value or a list of values", name)
+		}
+	}
+	if !secrets {
+		return values, nil
+	}
+
+	for name, value := range values {
+		expanded, err := expandConfigEnv(value)
+		if err != nil {
+			return nil, fmt.Errorf("setting %q: %w", name, err)
+		}
+		values[name] = expanded
+	}
+	for name, path := range files {
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("setting %q is set both inline and from a file", name)
+		}
+		path, err := expandConfigEnv(path)
+		if err != nil {
+			return nil, fmt.Errorf("setting %q: %w", name+configFileSuffix, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", name+configFileSuffix, err)
+		}
+		// Secret files usually end with a newline
+		values[name] = strings.TrimRight(string(data), "\r\n")
+	}
+	return values, nil
+}
+
+func expandConfigEnv(value string) (string, error) {
+	// An unset variable is an error, an empty secret would go unnoticed
+	var missing []string
+	expanded := configEnvVar.ReplaceAllStringFunc(value, func(ref string) string {
+		name := configEnvVar.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return env
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+func recordFlagSources() {
+	flag.VisitAll(func(f *flag.Flag) {
+		flagSources[f.Name] = "default"
+	})
+	flag.Visit(func(f *flag.Flag) {
+		flagSources[f.Name] = "command line"
+		if configFileFlags[f.Name] {
+			flagSources[f.Name] = "config file"
+		}
+	})
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Daemon defaults used when the container doesn't override them
+	defaultStopTimeout = 10
+	defaultStopSignal  = "SIGTERM"
+
+	defaultHealthcheckInterval = 30 * time.Second
+	defaultHealthcheckTimeout  = 30 * time.Second
+	defaultHealthcheckRetries  = 3
+)
+
+var (
+	commandMaxLength = flag.Int("commandMaxLength", 64, "Maximum length of the entrypoint and command labels, longer values are truncated (the hash covers the full value)")
+	envLabels        = flag.String("envLabels", "", "Comma separated environment variable names (e.g. APP_VERSION,GIT_SHA) whose values become labels of docker_container_env_info")
+
+	// Labels come from envLabels, so the metric is created on the first collection
+	containerEnvInfo *prometheus.GaugeVec
+	envLabelNames    []string // environment variable names, in label order
+	configCollector  *collector
+
+	validLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// DNS configuration per container
+	containerDNSServerInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_dns_server_info",
+			Help: "DNS servers configured for the container",
+		},
+		[]string{"container_name", "server"},
+	)
+	containerDNSSearchInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_dns_search_info",
+			Help: "DNS search domains configured for the container",
+		},
+		[]string{"container_name", "domain"},
+	)
+	containerExtraHostInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_extra_host_info",
+			Help: "Extra /etc/hosts entries configured for the container",
+		},
+		[]string{"container_name", "host", "address"},
+	)
+	containerHostnameInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_hostname_info",
+			Help: "Hostname and domainname configured for the container",
+		},
+		[]string{"container_name", "hostname", "domainname"},
+	)
+	containerIPInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_ip_info",
+			Help: "Address of the container on a network, IPv4 and global IPv6",
+		},
+		[]string{"container_name", "network", "address", "family"},
+	)
+
+	// Containers whose image reference now points at a newer local image
+	containerImageSuperseded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_image_superseded_locally",
+			Help: "Whether the image reference of the container resolves to a different, already pulled image than it was created from",
+		},
+		[]string{"container_name", "image"},
+	)
+
+	// Logging configuration per container
+	containerLogDriverInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_log_driver_info",
+			Help: "Logging driver of the container and its rotation options",
+		},
+		[]string{"container_name", "driver", "max_size", "max_file"},
+	)
+	containerLogUnbounded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_log_unbounded",
+			Help: "Whether the container logs to json-file without a max-size (1) or not (0)",
+		},
+		[]string{"container_name"},
+	)
+
+	// Device mappings and shared memory per container
+	containerDevices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_devices",
+			Help: "Number of host devices mapped into the container",
+		},
+		[]string{"container_name"},
+	)
+	containerDeviceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_device_info",
+			Help: "Host devices mapped into the container",
+		},
+		[]string{"container_name", "path_on_host", "path_in_container", "permissions"},
+	)
+	containerShmSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_shm_size_bytes",
+			Help: "Size of /dev/shm configured for the container",
+		},
+		[]string{"container_name"},
+	)
+
+	// Entrypoint and command per container
+	containerCommandInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_command_info",
+			Help: "Entrypoint and command of the container, truncated, with a hash of the full value and whether they differ from the image defaults",
+		},
+		[]string{"container_name", "entrypoint", "command", "hash", "overridden"},
+	)
+
+	// Cgroup placement per container
+	containerCgroupInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_cgroup_info",
+			Help: "Cgroup parent and cpuset placement configured for the container",
+		},
+		[]string{"container_name", "cgroup_parent", "cpuset_cpus", "cpuset_mems"},
+	)
+
+	// Most CPU a container can use, whichever of its quota, its cpuset and
+	// the host CPUs is smallest
+	containerCPUCapacity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_cpu_capacity_cores",
+			Help: "Most CPU cores the container can use, from its CPU quota, its cpuset and the host CPUs",
+		},
+		[]string{"container_name"},
+	)
+
+	// Ulimits per container
+	containerUlimitSoft = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_ulimit_soft",
+			Help: "Soft ulimit configured for the container",
+		},
+		[]string{"container_name", "ulimit"},
+	)
+	containerUlimitHard = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_ulimit_hard",
+			Help: "Hard ulimit configured for the container",
+		},
+		[]string{"container_name", "ulimit"},
+	)
+
+	// Stop behaviour per container
+	containerStopTimeout = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_stop_timeout_seconds",
+			Help: "Time the daemon waits after the stop signal before killing the container",
+		},
+		[]string{"container_name"},
+	)
+	containerStopSignalInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_stop_signal_info",
+			Help: "Signal sent to stop the container",
+		},
+		[]string{"container_name", "signal"},
+	)
+
+	// Healthcheck configuration per container
+	containerHealthcheckConfigured = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_healthcheck_configured",
+			Help: "Whether the container has a healthcheck defined (1) or not (0)",
+		},
+		[]string{"container_name"},
+	)
+	containerHealthcheckInterval = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_healthcheck_interval_seconds",
+			Help: "Time between healthcheck runs of the container",
+		},
+		[]string{"container_name"},
+	)
+	containerHealthcheckTimeout = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_healthcheck_timeout_seconds",
+			Help: "Time after which a healthcheck run of the container is considered failed",
+		},
+		[]string{"container_name"},
+	)
+	containerHealthcheckStartPeriod = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_healthcheck_start_period_seconds",
+			Help: "Time after start during which failed healthchecks of the container don't count",
+		},
+		[]string{"container_name"},
+	)
+	containerHealthcheckRetries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_healthcheck_retries",
+			Help: "Consecutive healthcheck failures needed to mark the container unhealthy",
+		},
+		[]string{"container_name"},
+	)
+
+	// All container configuration metrics, reset every cycle
+	configGauges = []*prometheus.GaugeVec{
+		containerDNSServerInfo,
+		containerDNSSearchInfo,
+		containerExtraHostInfo,
+		containerHostnameInfo,
+		containerIPInfo,
+		containerImageSuperseded,
+		containerLogDriverInfo,
+		containerLogUnbounded,
+		containerDevices,
+		containerDeviceInfo,
+		containerShmSize,
+		containerCommandInfo,
+		containerCgroupInfo,
+		containerCPUCapacity,
+		containerUlimitSoft,
+		containerUlimitHard,
+		containerStopTimeout,
+		containerStopSignalInfo,
+		containerHealthcheckConfigured,
+		containerHealthcheckInterval,
+		containerHealthcheckTimeout,
+		containerHealthcheckStartPeriod,
+		containerHealthcheckRetries,
+	}
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range configGauges {
+		metrics = append(metrics, g)
+	}
+	configCollector = registerCollector("config", collectConfigMetrics, metrics...)
+}
+
+func collectConfigMetrics(ctx context.Context, cli *client.Client) {
+	// List all containers
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	// Clear old metrics to avoid duplicates
+	for _, g := range configGauges {
+		g.Reset()
+	}
+	if containerEnvInfo == nil && *envLabels != "" {
+		newEnvInfo()
+	}
+	if containerEnvInfo != nil {
+		containerEnvInfo.Reset()
+	}
+
+	// Caps the CPU capacity of containers, unknown when the daemon doesn't answer
+	hostCPUs := 0
+	if info, err := cli.Info(ctx); err != nil {
+		logger.Error("Error getting Docker info", zap.Error(err))
+	} else {
+		hostCPUs = info.NCPU
+	}
+
+	// Image configs are shared by containers of the same image
+	imageConfigs := map[string]*typeContainer.Config{}
+	// Local image IDs of image references
+	imageIDs := map[string]string{}
+
+	for _, container := range containers {
+		containerName := container.Names[0]
+
+		// Configuration is only available from the full inspect
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+		if info.HostConfig == nil {
+			continue
+		}
+
+		setDNSMetrics(containerName, info)
+		setIPMetrics(containerName, info)
+		setLogMetrics(containerName, info)
+		setDeviceMetrics(containerName, info)
+		setCgroupMetrics(containerName, info)
+		setCPUCapacityMetrics(containerName, info, hostCPUs)
+		setUlimitMetrics(containerName, info)
+		setStopMetrics(containerName, info)
+		setHealthcheckMetrics(containerName, info)
+		setEnvMetrics(containerName, info)
+
+		imageConfig, ok := imageConfigs[info.Image]
+		if !ok {
+			image, _, err := cli.ImageInspectWithRaw(ctx, info.Image)
+			if err != nil {
+				logger.Error("Error inspecting image for container", zap.String("containerName", containerName), zap.Error(err))
+				setCollectionError(containerName, stageImageInspect, err)
+			}
+			imageConfig = image.Config
+			imageConfigs[info.Image] = imageConfig
+		}
+		setCommandMetrics(containerName, info, imageConfig)
+		setSupersededMetrics(ctx, cli, containerName, info, imageIDs)
+	}
+}
+
+func setSupersededMetrics(ctx context.Context, cli *client.Client, containerName string, info types.ContainerJSON, imageIDs map[string]string) {
+	if info.Config == nil || info.Config.Image == "" {
+		return
+	}
+	superseded, ok := imageSuperseded(ctx, cli, info, imageIDs)
+	if !ok {
+		return
+	}
+	value := 0.0
+	if superseded {
+		value = 1
+	}
+	containerImageSuperseded.WithLabelValues(containerName, info.Config.Image).Set(value)
+}
+
+func imageSuperseded(ctx context.Context, cli *client.Client, info types.ContainerJSON, imageIDs map[string]string) (superseded, ok bool) {
+	ref := info.Config.Image
+	imageID, cached := imageIDs[ref]
+	if !cached {
+		// References by ID or digest always resolve to the same image
+		image, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		if err != nil {
+			// The tag may have been removed, nothing newer to compare with
+			logger.Debug("Error resolving image reference", zap.String("image", ref), zap.Error(err))
+		}
+		imageID = image.ID
+		imageIDs[ref] = imageID
+	}
+	if imageID == "" {
+		return false, false
+	}
+	return imageID != info.Image, true
+}
+
+func setDNSMetrics(containerName string, info types.ContainerJSON) {
+	for _, server := range info.HostConfig.DNS {
+		containerDNSServerInfo.WithLabelValues(containerName, server).Set(1)
+	}
+	for _, domain := range info.HostConfig.DNSSearch {
+		containerDNSSearchInfo.WithLabelValues(containerName, domain).Set(1)
+	}
+	for _, entry := range info.HostConfig.ExtraHosts {
+		// Entries are host:ip or host=ip, the address may be IPv6
+		host, address, ok := strings.Cut(entry, "=")
+		if !ok {
+			host, address, _ = strings.Cut(entry, ":")
+		}
+		containerExtraHostInfo.WithLabelValues(containerName, host, address).Set(1)
+	}
+	// Defaults to the short container ID, which is what applications log
+	if info.Config != nil {
+		containerHostnameInfo.WithLabelValues(containerName, info.Config.Hostname, info.Config.Domainname).Set(1)
+	}
+}
+
+func setIPMetrics(containerName string, info types.ContainerJSON) {
+	if info.NetworkSettings == nil {
+		return
+	}
+	// IPv6-only networks have no IPv4 address, dual-stack ones have both
+	for name, network := range info.NetworkSettings.Networks {
+		if network == nil {
+			continue
+		}
+		if network.IPAddress != "" {
+			containerIPInfo.WithLabelValues(containerName, name, network.IPAddress, "ipv4").Set(1)
+		}
+		if network.GlobalIPv6Address != "" {
+			containerIPInfo.WithLabelValues(containerName, name, network.GlobalIPv6Address, "ipv6").Set(1)
+		}
+	}
+}
+
+func setLogMetrics(containerName string, info types.ContainerJSON) {
+	logConfig := info.HostConfig.LogConfig
+	maxSize := logConfig.Config["max-size"]
+	containerLogDriverInfo.WithLabelValues(containerName, logConfig.Type, maxSize, logConfig.Config["max-file"]).Set(1)
+
+	// json-file never rotates unless max-size is set
+	unbounded := 0.0
+	if logConfig.Type == "json-file" && maxSize == "" {
+		unbounded = 1
+	}
+	containerLogUnbounded.WithLabelValues(containerName).Set(unbounded)
+}
+
+func newEnvInfo() {
+	labels := []string{"container_name"}
+	for _, name := range splitList(*envLabels) {
+		label := strings.ToLower(name)
+		if !validLabelName.MatchString(label) || slices.Contains(labels, label) {
+			logger.Warn("Skipping environment variable not usable as a label", zap.String("name", name))
+			continue
+		}
+		labels = append(labels, label)
+		envLabelNames = append(envLabelNames, name)
+	}
+
+	containerEnvInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_env_info",
+			Help: "Values of the allowlisted environment variables of the container, empty when unset",
+		},
+		labels,
+	)
+	configCollector.registry.MustRegister(containerEnvInfo)
+}
+
+func setEnvMetrics(containerName string, info types.ContainerJSON) {
+	if containerEnvInfo == nil || info.Config == nil {
+		return
+	}
+	env := map[string]string{}
+	for _, variable := range info.Config.Env {
+		name, value, _ := strings.Cut(variable, "=")
+		env[name] = value
+	}
+	values := []string{containerName}
+	for _, name := range envLabelNames {
+		values = append(values, env[name])
+	}
+	containerEnvInfo.WithLabelValues(values...).Set(1)
+}
+
+func setDeviceMetrics(containerName string, info types.ContainerJSON) {
+	containerDevices.WithLabelValues(containerName).Set(float64(len(info.HostConfig.Devices)))
+	for _, device := range info.HostConfig.Devices {
+		containerDeviceInfo.WithLabelValues(containerName, device.PathOnHost, device.PathInContainer, device.CgroupPermissions).Set(1)
+	}
+	containerShmSize.WithLabelValues(containerName).Set(float64(info.HostConfig.ShmSize))
+}
+
+func setCommandMetrics(containerName string, info types.ContainerJSON, imageConfig *typeContainer.Config) {
+	if info.Config == nil {
+		return
+	}
+	entrypoint := info.Config.Entrypoint
+	command := info.Config.Cmd
+
+	// Hash the full value so overrides longer than the labels are still told apart
+	hash := shortHash(strings.Join(append(append([]string{}, entrypoint...), command...), "\x00"))
+
+	overridden := "unknown"
+	if imageConfig != nil {
+		overridden = strconv.FormatBool(!slices.Equal(entrypoint, imageConfig.Entrypoint) || !slices.Equal(command, imageConfig.Cmd))
+	}
+
+	containerCommandInfo.WithLabelValues(containerName, truncate(strings.Join(entrypoint, " ")), truncate(strings.Join(command, " ")), hash, overridden).Set(1)
+}
+
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func truncate(value string) string {
+	if *commandMaxLength < 0 || len(value) <= *commandMaxLength {
+		return value
+	}
+	// Cut on a rune boundary, label values must be valid UTF-8
+	end := *commandMaxLength
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end] + "..."
+}
+
+func setCgroupMetrics(containerName string, info types.ContainerJSON) {
+	resources := info.HostConfig.Resources
+	containerCgroupInfo.WithLabelValues(containerName, info.HostConfig.CgroupParent, resources.CpusetCpus, resources.CpusetMems).Set(1)
+}
+
+func setCPUCapacityMetrics(containerName string, info types.ContainerJSON, hostCPUs int) {
+	capacity := cpuQuotaCores(info.HostConfig)
+	if cpus := cpusetCount(info.HostConfig.CpusetCpus); cpus > 0 && (capacity <= 0 || float64(cpus) < capacity) {
+		capacity = float64(cpus)
+	}
+	if hostCPUs > 0 && (capacity <= 0 || float64(hostCPUs) < capacity) {
+		capacity = float64(hostCPUs)
+	}
+	if capacity <= 0 {
+		return
+	}
+	containerCPUCapacity.WithLabelValues(containerName).Set(capacity)
+}
+
+func cpusetCount(cpuset string) int {
+	// Lists and ranges of CPUs as in 0-3,8, empty for all CPUs
+	count := 0
+	for _, part := range strings.Split(cpuset, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if first == "" {
+			continue
+		}
+		if !isRange {
+			count++
+			continue
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			continue
+		}
+		count += to - from + 1
+	}
+	return count
+}
+
+func setUlimitMetrics(containerName string, info types.ContainerJSON) {
+	// Only explicitly configured ulimits, containers without one inherit the daemon's
+	for _, ulimit := range info.HostConfig.Ulimits {
+		if ulimit == nil {
+			continue
+		}
+		containerUlimitSoft.WithLabelValues(containerName, ulimit.Name).Set(float64(ulimit.Soft))
+		containerUlimitHard.WithLabelValues(containerName, ulimit.Name).Set(float64(ulimit.Hard))
+	}
+}
+
+func setStopMetrics(containerName string, info types.ContainerJSON) {
+	if info.Config == nil {
+		return
+	}
+
+	stopTimeout := defaultStopTimeout
+	if info.Config.StopTimeout != nil {
+		stopTimeout = *info.Config.StopTimeout
+	}
+	containerStopTimeout.WithLabelValues(containerName).Set(float64(stopTimeout))
+
+	stopSignal := info.Config.StopSignal
+	if stopSignal == "" {
+		stopSignal = defaultStopSignal
+	}
+	containerStopSignalInfo.WithLabelValues(containerName, stopSignal).Set(1)
+}
+
+func setHealthcheckMetrics(containerName string, info types.ContainerJSON) {
+	if info.Config == nil {
+		return
+	}
+
+	// The image healthcheck is already merged in, NONE disables it
+	healthcheck := info.Config.Healthcheck
+	if healthcheck == nil || len(healthcheck.Test) == 0 || healthcheck.Test[0] == "NONE" {
+		containerHealthcheckConfigured.WithLabelValues(containerName).Set(0)
+		return
+	}
+	containerHealthcheckConfigured.WithLabelValues(containerName).Set(1)
+
+	// Unset values fall back to the daemon defaults
+	interval := cmp.Or(healthcheck.Interval, defaultHealthcheckInterval)
+	timeout := cmp.Or(healthcheck.Timeout, defaultHealthcheckTimeout)
+	retries := cmp.Or(healthcheck.Retries, defaultHealthcheckRetries)
+	containerHealthcheckInterval.WithLabelValues(containerName).Set(interval.Seconds())
+	containerHealthcheckTimeout.WithLabelValues(containerName).Set(timeout.Seconds())
+	containerHealthcheckStartPeriod.WithLabelValues(containerName).Set(healthcheck.StartPeriod.Seconds())
+	containerHealthcheckRetries.WithLabelValues(containerName).Set(float64(retries))
+}
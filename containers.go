@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	maxContainers = flag.Int("maxContainers", 0, "Maximum number of containers listed per collection, the most recently created are kept (0 is unlimited)")
+
+	containerListTruncated = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_container_list_truncated",
+			Help: "Whether the last container list hit maxContainers and containers were left out",
+		},
+	)
+
+	// Container list shared by the collectors of a cycle in low power mode
+	cycleListMu     sync.Mutex
+	cycleContainers []types.Container
+)
+
+func init() {
+	exporterRegistry.MustRegister(containerListTruncated)
+}
+
+func resetContainerList() {
+	cycleListMu.Lock()
+	defer cycleListMu.Unlock()
+	cycleContainers = nil
+}
+
+func listContainers(ctx context.Context, cli *client.Client, options typeContainer.ListOptions) ([]types.Container, error) {
+	// All collectors list running containers, one request per cycle does
+	if !*lowPower || options.All || options.Filters.Len() > 0 {
+		return limitedContainerList(ctx, cli, options)
+	}
+	cycleListMu.Lock()
+	defer cycleListMu.Unlock()
+	if cycleContainers != nil {
+		return cycleContainers, nil
+	}
+	containers, err := limitedContainerList(ctx, cli, options)
+	if err != nil {
+		return nil, err
+	}
+	cycleContainers = containers
+	return containers, nil
+}
+
+func limitedContainerList(ctx context.Context, cli *client.Client, options typeContainer.ListOptions) ([]types.Container, error) {
+	if *maxContainers <= 0 {
+		return cli.ContainerList(ctx, options)
+	}
+
+	// The API has no pagination, Limit makes the daemon return only the newest
+	// containers instead of the whole list. One more tells if any were left out.
+	options.Limit = *maxContainers + 1
+	containers, err := cli.ContainerList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) <= *maxContainers {
+		containerListTruncated.Set(0)
+		return containers, nil
+	}
+	logger.Debug("Container list truncated", zap.Int("maxContainers", *maxContainers))
+	containerListTruncated.Set(1)
+	return containers[:*maxContainers], nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Grafana panel layout, on its 24 column grid
+	dashboardPanelWidth  = 12
+	dashboardPanelHeight = 8
+
+	// Matches the instance picked in the dashboard
+	dashboardSelector = `instance=~"$instance"`
+)
+
+// dashboardPanel is a time series panel of a collector's metrics
+type dashboardPanel struct {
+	collector string
+	title     string
+	unit      string
+	legend    string
+	expr      string
+}
+
+// dashboardPanels returns the panels of all collectors, with metric names
+// as exported after overrides
+func dashboardPanels() []dashboardPanel {
+	// Gauge and counter selectors restricted to the picked instance
+	g := func(name string, matchers ...string) string {
+		return metricSelector(exportedName(name, false), matchers)
+	}
+	c := func(name string, matchers ...string) string {
+		return metricSelector(exportedName(name, true), matchers)
+	}
+
+	return []dashboardPanel{
+		{"image", "Containers per image", "short", "{{image_repo}}", fmt.Sprintf("sum by (image_repo) (%s)", g("docker_image_containers"))},
+		{"image", "Containers not running their host architecture", "short", "{{container_name}}", fmt.Sprintf("%s == 1", g("docker_container_arch_mismatch"))},
+		{"distribution", "Containers running outdated images", "short", "{{container_name}} {{image_repo}}", fmt.Sprintf("%s == 0", g("docker_container_image_up_to_date"))},
+		{"distribution", "Image age", "s", "{{container_name}}", g("docker_container_image_age_seconds")},
+		{"stats", "CPU used by all containers", "short", "cores", g("docker_containers_cpu_cores")},
+		{"stats", "Memory used by all containers", "bytes", "memory", g("docker_containers_memory_bytes")},
+		{"stats", "Network received", "Bps", "{{container_name}}", fmt.Sprintf("sum by (container_name) (rate(%s[5m]))", g("docker_container_network_rx_bytes_total"))},
+		{"stats", "Network sent", "Bps", "{{container_name}}", fmt.Sprintf("sum by (container_name) (rate(%s[5m]))", g("docker_container_network_tx_bytes_total"))},
+		{"config", "CPU capacity", "short", "{{container_name}}", g("docker_container_cpu_capacity_cores")},
+		{"config", "Containers without log rotation", "short", "{{container_name}}", fmt.Sprintf("%s == 1", g("docker_container_log_unbounded"))},
+		{"cgroup", "Pressure stalls (some, 60s)", "percent", "{{container_name}} {{resource}}", g("docker_container_pressure_percent", `kind="some"`, `window="60s"`)},
+		{"cgroup", "Kernel memory", "bytes", "{{container_name}}", g("docker_container_memory_kernel_bytes")},
+		{"cgroup", "Swap", "bytes", "{{container_name}}", g("docker_container_memory_swap_bytes")},
+		{"storage", "Writable layer inodes", "short", "{{container_name}}", g("docker_container_writable_layer_inodes")},
+		{"mounts", "tmpfs usage", "percentunit", "{{container_name}} {{mountpoint}}", fmt.Sprintf("%s / %s", g("docker_container_tmpfs_used_bytes"), g("docker_container_tmpfs_size_bytes"))},
+		{"mounts", "Bind mount filesystem free", "bytes", "{{container_name}} {{destination}}", g("docker_container_bind_mount_free_bytes")},
+		{"netns", "Conntrack entries", "short", "{{container_name}}", g("docker_container_conntrack_entries")},
+		{"netns", "TCP sockets", "short", "{{container_name}} {{state}}", g("docker_container_tcp_sockets")},
+		{"exits", "Container exits per hour", "short", "{{exit_class}}", fmt.Sprintf("sum by (exit_class) (increase(%s[1h]))", c("docker_container_exits_total"))},
+		{"restarts", "Restarts in the last hour", "short", "{{container_name}}", fmt.Sprintf("%s > 0", g("docker_container_restarts_last_hour"))},
+		{"exec", "Exec sessions", "short", "{{container_name}}", g("docker_container_exec_sessions")},
+		{"pulls", "Image pulls in progress", "short", "pulls", g("docker_image_pulls_in_progress")},
+		{"pulls", "Layer downloads in progress", "short", "downloads", g("docker_image_layer_downloads_in_progress")},
+		{"pulls", "Image pulls per hour", "short", "{{registry}}", fmt.Sprintf("sum by (registry) (increase(%s[1h]))", c("docker_image_pulls_total"))},
+		{"builds", "Build steps in progress", "short", "steps", g("docker_build_steps_in_progress")},
+		{"transfers", "Image transfers per hour", "short", "{{operation}}", fmt.Sprintf("sum by (operation) (increase(%s[1h]))", c("docker_image_transfers_total"))},
+		{"prune", "Reclaimable space", "bytes", "{{type}}", g("docker_prune_reclaimable_bytes")},
+		{"tenants", "CPU per tenant", "short", "{{tenant}}", fmt.Sprintf("sum by (tenant) (rate(%s[5m]))", c("docker_tenant_cpu_seconds_total"))},
+		{"tenants", "Memory per tenant", "bytes", "{{tenant}}", g("docker_tenant_memory_bytes")},
+		{"swarm", "Stack replicas running", "short", "{{stack}}", g("docker_stack_replicas_running")},
+		{"swarm", "Stack replicas desired", "short", "{{stack}}", g("docker_stack_replicas_desired")},
+		{"checkpoints", "Checkpoints", "short", "{{container_name}}", g("docker_container_checkpoints")},
+		{"dind", "Nested daemons up", "short", "{{parent_container}}", g("docker_dind_daemon_up")},
+	}
+}
+
+func metricSelector(name string, matchers []string) string {
+	if len(matchers) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(matchers, ",") + "}"
+}
+
+func writeDashboard(w io.Writer, enabled []*collector) error {
+	var panels []map[string]any
+	for _, panel := range dashboardPanels() {
+		if !collectorEnabled(enabled, panel.collector) {
+			continue
+		}
+		// Two panels per row
+		i := len(panels)
+		panels = append(panels, map[string]any{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      panel.title,
+			"datasource": map[string]any{"type": "prometheus", "uid": "${datasource}"},
+			"gridPos": map[string]any{
+				"x": (i % 2) * dashboardPanelWidth,
+				"y": (i / 2) * dashboardPanelHeight,
+				"w": dashboardPanelWidth,
+				"h": dashboardPanelHeight,
+			},
+			"fieldConfig": map[string]any{"defaults": map[string]any{"unit": panel.unit}, "overrides": []any{}},
+			"targets": []map[string]any{
+				{"refId": "A", "expr": panel.expr, "legendFormat": panel.legend},
+			},
+		})
+	}
+
+	// Instances are picked from a series every exporter has
+	instanceQuery := fmt.Sprintf("label_values(%s, instance)", exportedName("docker_prom_container_list_truncated", false))
+	dashboard := map[string]any{
+		"title":         "Docker containers",
+		"uid":           "docker-prom",
+		"tags":          []string{"docker", "docker-prom"},
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]any{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{
+			"list": []map[string]any{
+				{"name": "datasource", "type": "datasource", "query": "prometheus"},
+				{
+					"name":       "instance",
+					"type":       "query",
+					"datasource": map[string]any{"type": "prometheus", "uid": "${datasource}"},
+					"query":      instanceQuery,
+					"definition": instanceQuery,
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".*",
+				},
+			},
+		},
+		"panels": panels,
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(dashboard)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Operations of derived metrics
+	derivedRatio = "ratio"
+	derivedSum   = "sum"
+	derivedCount = "count"
+)
+
+var (
+	derivedMetricsFile = flag.String("derivedMetrics", "", "YAML file of gauges derived from other metrics at collection time (ratio, sum or count), for downstreams without recording rules")
+
+	derivedMetrics []derivedMetric
+)
+
+// derivedMetric is a gauge computed from the gathered metrics
+type derivedMetric struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// ratio, sum or count
+	Op string `yaml:"op"`
+	// Source of sum and count, numerator of ratio
+	Metric string `yaml:"metric"`
+	// Denominator of ratio
+	Divisor string `yaml:"divisor"`
+	// Labels to group sum and count by, or to match ratio series on
+	By []string `yaml:"by"`
+}
+
+func loadDerivedMetrics(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading derived metrics: %w", err)
+	}
+	var metrics []derivedMetric
+	if err := yaml.Unmarshal(data, &metrics); err != nil {
+		return fmt.Errorf("error parsing derived metrics %s: %w", path, err)
+	}
+	for i, m := range metrics {
+		if !model.IsValidMetricName(model.LabelValue(m.Name)) {
+			return fmt.Errorf("invalid derived metric name %q", m.Name)
+		}
+		if m.Metric == "" {
+			return fmt.Errorf("derived metric %s has no source metric", m.Name)
+		}
+		switch m.Op {
+		case derivedRatio:
+			if m.Divisor == "" {
+				return fmt.Errorf("derived metric %s has no divisor", m.Name)
+			}
+			// Container metrics are the usual ratios
+			if len(m.By) == 0 {
+				metrics[i].By = []string{"container_name"}
+			}
+		case derivedSum, derivedCount:
+		default:
+			return fmt.Errorf("unknown operation %q of derived metric %s", m.Op, m.Name)
+		}
+		for _, label := range m.By {
+			if !validLabelName.MatchString(label) {
+				return fmt.Errorf("invalid label %q of derived metric %s", label, m.Name)
+			}
+		}
+		if m.Help == "" {
+			metrics[i].Help = fmt.Sprintf("Derived %s of %s", m.Op, m.Metric)
+		}
+		slices.Sort(metrics[i].By)
+	}
+	derivedMetrics = metrics
+	return nil
+}
+
+func addDerivedMetrics(families []*dto.MetricFamily) []*dto.MetricFamily {
+	if len(derivedMetrics) == 0 {
+		return families
+	}
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+
+	for _, d := range derivedMetrics {
+		if _, ok := byName[d.Name]; ok {
+			logger.Debug("Derived metric shadows a gathered metric, skipping", zap.String("metric", d.Name))
+			continue
+		}
+		// Missing sources are normal, e.g. before the first containers start
+		source, ok := byName[d.Metric]
+		if !ok {
+			continue
+		}
+		var metrics []*dto.Metric
+		switch d.Op {
+		case derivedRatio:
+			divisor, ok := byName[d.Divisor]
+			if !ok {
+				continue
+			}
+			metrics = ratioSeries(source, divisor, d.By)
+		default:
+			metrics = groupSeries(source, d.By, d.Op == derivedCount)
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		name, help, gauge := d.Name, d.Help, dto.MetricType_GAUGE
+		families = append(families, &dto.MetricFamily{Name: &name, Help: &help, Type: &gauge, Metric: metrics})
+	}
+	return families
+}
+
+func ratioSeries(numerator, divisor *dto.MetricFamily, on []string) []*dto.Metric {
+	divisors := map[string]float64{}
+	for _, m := range divisor.GetMetric() {
+		if value, ok := sampleValue(m); ok {
+			divisors[seriesGroup(m, on)] = value
+		}
+	}
+	var metrics []*dto.Metric
+	for _, m := range numerator.GetMetric() {
+		value, ok := sampleValue(m)
+		key := seriesGroup(m, on)
+		d, found := divisors[key]
+		// Unlimited resources report a limit of zero
+		if !ok || !found || d == 0 {
+			continue
+		}
+		metrics = append(metrics, derivedSeries(m, on, value/d))
+	}
+	return metrics
+}
+
+func groupSeries(source *dto.MetricFamily, by []string, count bool) []*dto.Metric {
+	values := map[string]float64{}
+	first := map[string]*dto.Metric{}
+	var order []string
+	for _, m := range source.GetMetric() {
+		value, ok := sampleValue(m)
+		if !ok {
+			continue
+		}
+		key := seriesGroup(m, by)
+		if _, seen := first[key]; !seen {
+			first[key] = m
+			order = append(order, key)
+		}
+		if count {
+			value = 1
+		}
+		values[key] += value
+	}
+	metrics := make([]*dto.Metric, 0, len(order))
+	for _, key := range order {
+		metrics = append(metrics, derivedSeries(first[key], by, values[key]))
+	}
+	return metrics
+}
+
+func sampleValue(m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), true
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), true
+	}
+	// Histograms and summaries have no single value
+	return 0, false
+}
+
+func seriesGroup(m *dto.Metric, by []string) string {
+	values := make([]string, len(by))
+	for _, label := range m.GetLabel() {
+		if i := slices.Index(by, label.GetName()); i >= 0 {
+			values[i] = label.GetValue()
+		}
+	}
+	return strings.Join(values, "\xff")
+}
+
+func derivedSeries(m *dto.Metric, by []string, value float64) *dto.Metric {
+	// Only the grouping labels are kept, in the sorted order of by
+	var labels []*dto.LabelPair
+	for _, name := range by {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == name {
+				labels = append(labels, label)
+			}
+		}
+	}
+	return &dto.Metric{Label: labels, Gauge: &dto.Gauge{Value: &value}}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Time allowed for each call to a nested daemon
+	dindTimeout = 5 * time.Second
+)
+
+var (
+	dindLabel = flag.String("dindLabel", "docker-prom.dind", "Container label giving the address of a nested Docker daemon, as unix:///<socket path in the container on a volume> or tcp://[host]:<port> (plain TCP, the container IP when host is empty)")
+
+	dindUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_dind_daemon_up",
+			Help: "Whether the nested Docker daemon of the container answered (1) or not (0)",
+		},
+		[]string{"parent_container"},
+	)
+	dindContainerInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_dind_container_info",
+			Help: "Container of a nested Docker daemon, by the container running the daemon",
+		},
+		[]string{"parent_container", "container_name", "image", "state"},
+	)
+	dindContainers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_dind_containers",
+			Help: "Number of containers of a nested Docker daemon by state",
+		},
+		[]string{"parent_container", "state"},
+	)
+
+	dindGauges = []*prometheus.GaugeVec{dindUp, dindContainerInfo, dindContainers}
+
+	// Clients of nested daemons by parent container ID and address, kept
+	// between cycles
+	dindClientsMu sync.Mutex
+	dindClients   = map[string]*dindClient{}
+)
+
+// dindClient is a client of the nested daemon of a container
+type dindClient struct {
+	address string
+	cli     *client.Client
+}
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range dindGauges {
+		metrics = append(metrics, g)
+	}
+	c := registerCollector("dind", collectDindMetrics, metrics...)
+	c.hostFiles = true
+}
+
+func collectDindMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range dindGauges {
+		g.Reset()
+	}
+	if *dindLabel == "" {
+		return
+	}
+
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	dindClientsMu.Lock()
+	defer dindClientsMu.Unlock()
+
+	seen := map[string]bool{}
+	for _, container := range containers {
+		address, ok := container.Labels[*dindLabel]
+		if !ok {
+			continue
+		}
+		parentName := container.Names[0]
+
+		// Addresses are relative to the container, resolved from the inspect
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", parentName), zap.Error(err))
+			setCollectionError(parentName, stageInspect, err)
+			continue
+		}
+		host, err := dindHost(info, address)
+		if err != nil {
+			logger.Error("Error resolving nested daemon address", zap.String("containerName", parentName), zap.String("address", address), zap.Error(err))
+			dindUp.WithLabelValues(parentName).Set(0)
+			continue
+		}
+
+		child, err := dindClientFor(container.ID, host)
+		if err != nil {
+			logger.Error("Error creating nested daemon client", zap.String("containerName", parentName), zap.Error(err))
+			dindUp.WithLabelValues(parentName).Set(0)
+			continue
+		}
+		seen[container.ID] = true
+
+		if err := setDindMetrics(ctx, child, parentName); err != nil {
+			logger.Error("Error collecting nested daemon containers", zap.String("containerName", parentName), zap.String("host", host), zap.Error(err))
+			dindUp.WithLabelValues(parentName).Set(0)
+			continue
+		}
+		dindUp.WithLabelValues(parentName).Set(1)
+	}
+
+	// Close clients of parents that went away
+	for id, c := range dindClients {
+		if !seen[id] {
+			c.cli.Close()
+			delete(dindClients, id)
+		}
+	}
+}
+
+func dindClientFor(parentID, host string) (*client.Client, error) {
+	if c, ok := dindClients[parentID]; ok {
+		if c.address == host {
+			return c.cli, nil
+		}
+		// The label or the container IP changed
+		c.cli.Close()
+		delete(dindClients, parentID)
+	}
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	dindClients[parentID] = &dindClient{address: host, cli: cli}
+	return cli, nil
+}
+
+func setDindMetrics(ctx context.Context, cli *client.Client, parentName string) error {
+	ctx, cancel := context.WithTimeout(ctx, dindTimeout)
+	defer cancel()
+
+	// Nested daemons are not limited by maxContainers, they're listed directly
+	containers, err := cli.ContainerList(ctx, typeContainer.ListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("error listing containers: %w", err)
+	}
+	states := map[string]int{}
+	for _, container := range containers {
+		states[container.State]++
+		dindContainerInfo.WithLabelValues(parentName, container.Names[0], container.Image, container.State).Set(1)
+	}
+	for state, count := range states {
+		dindContainers.WithLabelValues(parentName, state).Set(float64(count))
+	}
+	return nil
+}
+
+func dindHost(info types.ContainerJSON, address string) (string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", fmt.Errorf("error parsing address: %w", err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		// The socket is shared with the host through a volume or bind mount
+		socket := path.Clean(u.Path)
+		for _, m := range info.Mounts {
+			dest := path.Clean(m.Destination)
+			if socket != dest && !strings.HasPrefix(socket, dest+"/") {
+				continue
+			}
+			return "unix://" + hostPath(path.Join(m.Source, strings.TrimPrefix(socket, dest))), nil
+		}
+		return "", fmt.Errorf("socket %s is not on a mount of the container", socket)
+	case "tcp":
+		host := u.Hostname()
+		if host == "" {
+			if info.NetworkSettings == nil {
+				return "", fmt.Errorf("container has no network settings")
+			}
+			for _, network := range info.NetworkSettings.Networks {
+				if network != nil && network.IPAddress != "" {
+					host = network.IPAddress
+					break
+				}
+			}
+			if host == "" {
+				return "", fmt.Errorf("container has no IP address")
+			}
+		}
+		if u.Port() == "" {
+			return "", fmt.Errorf("address has no port")
+		}
+		return "tcp://" + net.JoinHostPort(host, u.Port()), nil
+	default:
+		return "", fmt.Errorf("unsupported scheme %q, must be unix or tcp", u.Scheme)
+	}
+}
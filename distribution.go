@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	distributionInterval = flag.Duration("distributionInterval", time.Hour, "How often the registry is asked for the digest and platforms of each image")
+
+	imageManifestPlatformInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_manifest_platform_info",
+			Help: "Platform offered by the manifest list of the image in the registry, and whether it's the one pulled",
+		},
+		[]string{"image_repo", "platform", "pulled"},
+	)
+	imageManifestPlatforms = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_manifest_platforms",
+			Help: "Number of platforms offered by the manifest list of the image in the registry",
+		},
+		[]string{"image_repo"},
+	)
+	containerImageUpToDate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_image_up_to_date",
+			Help: "Whether the image of the container has the digest the registry serves for its tag (1) or not (0)",
+		},
+		[]string{"container_name", "image_repo"},
+	)
+	containerImageAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_image_age_seconds",
+			Help: "Time since the image of the container was created",
+		},
+		[]string{"container_name", "image_repo"},
+	)
+
+	distributionGauges = []*prometheus.GaugeVec{imageManifestPlatformInfo, imageManifestPlatforms, containerImageUpToDate, containerImageAge}
+
+	// Registry answers per image reference, refreshed every distributionInterval
+	distributionMu    sync.Mutex
+	distributionCache = map[string]registryImage{}
+	// Image references in use by daemon, the cache is shared by all daemons
+	// and an answer is only forgotten once no daemon has the image
+	distributionRefs = map[string]map[string]bool{}
+)
+
+// registryImage is what the registry reports for an image reference
+type registryImage struct {
+	digest    string
+	platforms []string
+	fetchedAt time.Time
+}
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range distributionGauges {
+		metrics = append(metrics, g)
+	}
+	c := registerCollector("distribution", collectDistributionMetrics, metrics...)
+	c.dumpState = func() map[string]any {
+		distributionMu.Lock()
+		defer distributionMu.Unlock()
+		return map[string]any{"cachedImages": len(distributionCache)}
+	}
+	c.shrink = func() {
+		// Refetched the next cycle, at the steady state pace
+		distributionMu.Lock()
+		defer distributionMu.Unlock()
+		distributionCache = map[string]registryImage{}
+		distributionRefs = map[string]map[string]bool{}
+	}
+}
+
+func collectDistributionMetrics(ctx context.Context, cli *client.Client) {
+	images, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing images", zap.Error(err))
+		return
+	}
+
+	// Clear old metrics to avoid duplicates
+	for _, g := range distributionGauges {
+		g.Reset()
+	}
+
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	// Pulled images only, they are the ones known to the registry
+	var pulled []image.Summary
+	imagesByID := map[string]image.Summary{}
+	seen := map[string]bool{}
+	var refs []string
+	for _, img := range images {
+		imagesByID[img.ID] = img
+		if len(img.RepoTags) > 0 && len(img.RepoDigests) > 0 {
+			pulled = append(pulled, img)
+			if !seen[img.RepoTags[0]] {
+				seen[img.RepoTags[0]] = true
+				refs = append(refs, img.RepoTags[0])
+			}
+		}
+	}
+	// Containers are checked against the tag they were created from, which
+	// may not be the first tag of their image
+	containerRefs := map[string]string{}
+	for _, container := range containers {
+		img, ok := imagesByID[container.ImageID]
+		if !ok || len(img.RepoDigests) == 0 {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(container.Image)
+		if err != nil {
+			// Created from an image ID
+			continue
+		}
+		imageRepo := reference.FamiliarString(reference.TagNameOnly(named))
+		containerRefs[container.ID] = imageRepo
+		if !seen[imageRepo] {
+			seen[imageRepo] = true
+			refs = append(refs, imageRepo)
+		}
+	}
+	refreshRegistryImages(ctx, cli, refs)
+
+	distributionMu.Lock()
+	defer distributionMu.Unlock()
+
+	now := time.Now()
+	for _, container := range containers {
+		img, ok := imagesByID[container.ImageID]
+		if !ok {
+			continue
+		}
+		containerName := container.Names[0]
+		imageRepo, ok := containerRefs[container.ID]
+		if !ok {
+			// Locally built, or created from an image ID
+			imageRepo = container.Image
+		}
+		containerImageAge.WithLabelValues(containerName, imageRepo).Set(now.Sub(time.Unix(img.Created, 0)).Seconds())
+
+		if cached := distributionCache[imageRepo]; ok && cached.digest != "" {
+			upToDate := 0.0
+			if hasRepoDigest(img.RepoDigests, cached.digest) {
+				upToDate = 1
+			}
+			containerImageUpToDate.WithLabelValues(containerName, imageRepo).Set(upToDate)
+		}
+	}
+
+	for _, img := range pulled {
+		imageRepo := img.RepoTags[0]
+
+		cached := distributionCache[imageRepo]
+		if len(cached.platforms) == 0 {
+			continue
+		}
+
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, img.ID)
+		if err != nil {
+			logger.Error("Error inspecting image", zap.String("image", imageRepo), zap.Error(err))
+			continue
+		}
+		pulledPlatform := platformName(inspect.Os, inspect.Architecture, inspect.Variant)
+		for _, platform := range cached.platforms {
+			imageManifestPlatformInfo.WithLabelValues(imageRepo, platform, strconv.FormatBool(platform == pulledPlatform)).Set(1)
+		}
+		imageManifestPlatforms.WithLabelValues(imageRepo).Set(float64(len(cached.platforms)))
+	}
+
+	// Forget images that were removed from every daemon
+	distributionRefs[cli.DaemonHost()] = seen
+	for imageRepo := range distributionCache {
+		if !imageInUse(imageRepo) {
+			delete(distributionCache, imageRepo)
+		}
+	}
+}
+
+func imageInUse(imageRepo string) bool {
+	for _, refs := range distributionRefs {
+		if refs[imageRepo] {
+			return true
+		}
+	}
+	return false
+}
+
+func refreshRegistryImages(ctx context.Context, cli *client.Client, refs []string) {
+	distributionMu.Lock()
+	var stale []string
+	for _, imageRepo := range refs {
+		if cached, ok := distributionCache[imageRepo]; !ok || time.Since(cached.fetchedAt) > *distributionInterval {
+			stale = append(stale, imageRepo)
+		}
+	}
+	distributionMu.Unlock()
+
+	// The registry isn't asked with the lock held, other collectors read the cache
+	sem := make(chan struct{}, registryConcurrency())
+	var wg sync.WaitGroup
+	for _, imageRepo := range stale {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fetched, err := inspectRegistryImage(ctx, cli, imageRepo)
+
+			distributionMu.Lock()
+			defer distributionMu.Unlock()
+			if err != nil {
+				logger.Error("Error inspecting image in registry", zap.String("image", imageRepo), zap.Error(err))
+				// Retry next interval, keeping what was known
+				fetched = distributionCache[imageRepo]
+				fetched.fetchedAt = time.Now()
+			}
+			distributionCache[imageRepo] = fetched
+		}()
+	}
+	wg.Wait()
+}
+
+func inspectRegistryImage(ctx context.Context, cli *client.Client, ref string) (registryImage, error) {
+	// Anonymous access unless credentials are configured for the registry
+	inspect, err := cli.DistributionInspect(ctx, ref, registryAuth(ref))
+	if err != nil {
+		return registryImage{}, err
+	}
+	fetched := registryImage{digest: inspect.Descriptor.Digest.String(), fetchedAt: time.Now()}
+	for _, p := range inspect.Platforms {
+		fetched.platforms = append(fetched.platforms, platformName(p.OS, p.Architecture, p.Variant))
+	}
+	return fetched, nil
+}
+
+func registryOutdated(imageRepo string, repoDigests []string) (outdated, known bool) {
+	distributionMu.Lock()
+	cached, ok := distributionCache[imageRepo]
+	distributionMu.Unlock()
+	if !ok || cached.digest == "" {
+		return false, false
+	}
+	// The image is current when one of its repo digests matches
+	return !hasRepoDigest(repoDigests, cached.digest), true
+}
+
+func platformName(os, arch, variant string) string {
+	if variant == "" {
+		return os + "/" + arch
+	}
+	return os + "/" + arch + "/" + variant
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// Number of labels listed per metric family in the dry-run summary
+	dryRunTopLabels = 3
+)
+
+// labelCardinality is the number of distinct values of a label
+type labelCardinality struct {
+	name   string
+	values int
+}
+
+func printDryRun(w io.Writer, gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+
+	encoder := expfmt.NewEncoder(w, PromText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return fmt.Errorf("error encoding metrics: %w", err)
+		}
+	}
+
+	// Summary as comments, so the output stays valid exposition format
+	total := 0
+	fmt.Fprintf(w, "# dry-run: %d metric families\n", len(families))
+	for _, family := range families {
+		total += len(family.Metric)
+
+		values := map[string]map[string]bool{}
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if values[label.GetName()] == nil {
+					values[label.GetName()] = map[string]bool{}
+				}
+				values[label.GetName()][label.GetValue()] = true
+			}
+		}
+		var cardinalities []labelCardinality
+		for name, seen := range values {
+			cardinalities = append(cardinalities, labelCardinality{name: name, values: len(seen)})
+		}
+		sort.Slice(cardinalities, func(i, j int) bool {
+			if cardinalities[i].values != cardinalities[j].values {
+				return cardinalities[i].values > cardinalities[j].values
+			}
+			return cardinalities[i].name < cardinalities[j].name
+		})
+
+		var top []string
+		for i, c := range cardinalities {
+			if i == dryRunTopLabels {
+				break
+			}
+			top = append(top, fmt.Sprintf("%s=%d", c.name, c.values))
+		}
+		fmt.Fprintf(w, "# dry-run: %s %s series=%d labels=[%s]\n", family.GetName(), strings.ToLower(family.GetType().String()), len(family.Metric), strings.Join(top, " "))
+	}
+	fmt.Fprintf(w, "# dry-run: %d series total\n", total)
+	return nil
+}
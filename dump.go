@@ -0,0 +1,48 @@
+package main
+
+import (
+	"runtime"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func dumpState(enabled []*collector, fileOut *fileOutput) {
+	collectorStates := map[string]any{}
+	for _, c := range enabled {
+		state := map[string]any{}
+		if c.dumpState != nil {
+			state = c.dumpState()
+		}
+		if c.collect != nil {
+			state["lastCollectedAge"] = ageOf(c.lastCollected.Load())
+			state["lastDuration"] = time.Duration(c.lastDuration.Load()).String()
+		}
+		collectorStates[c.name] = state
+	}
+
+	state := map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"collectors": collectorStates,
+		"events": map[string]any{
+			"connected":    eventsConnected.Load(),
+			"lastEventAge": ageOf(lastEventAt.Load()),
+		},
+	}
+	// The file writer stops writing for a while after ENOSPC/EROFS
+	if fileOut != nil {
+		backoff, retryAt := fileOut.backoffState()
+		state["fileOutput"] = map[string]any{
+			"backoff": backoff.String(),
+			"retryAt": retryAt,
+		}
+	}
+	logger.Info("State dump", zap.Any("state", state))
+}
+
+func ageOf(unixNano int64) string {
+	if unixNano == 0 {
+		return "never"
+	}
+	return time.Since(time.Unix(0, unixNano)).String()
+}
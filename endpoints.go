@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	endpointLabel = "endpoint"
+)
+
+var (
+	endpointsFile = flag.String("endpoints", "", "YAML file of the Docker daemons to collect from, with name, host, optional TLS files and whether the daemon is local; their series get an endpoint label (default the daemon from the environment)")
+	scrapeMaxAge  = flag.Duration("scrapeMaxAge", 5*time.Second, "Scrapes and file writes within this long of the last collection are served from it instead of collecting again")
+
+	scrapeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_exporter_scrape_duration_seconds",
+			Help: "Duration of the last collection from the Docker daemon",
+		},
+		[]string{endpointLabel},
+	)
+	scrapeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_exporter_scrape_errors_total",
+			Help: "Number of collections skipped because the Docker daemon didn't answer a ping",
+		},
+		[]string{endpointLabel},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(scrapeDuration, scrapeErrors)
+}
+
+// endpointConfig is a Docker daemon of the endpoints file
+type endpointConfig struct {
+	Name string `yaml:"name"`
+	// As in DOCKER_HOST, unix:///var/run/docker.sock or tcp://host:2376
+	Host      string `yaml:"host"`
+	TLSCACert string `yaml:"tlsCACert"`
+	TLSCert   string `yaml:"tlsCert"`
+	TLSKey    string `yaml:"tlsKey"`
+	// The daemon runs on this host, collectors reading host files apply
+	Local bool `yaml:"local"`
+}
+
+// daemon is a Docker daemon metrics are collected from
+type daemon struct {
+	// Value of the endpoint label, empty for the daemon of the environment
+	name string
+	cli  *client.Client
+	// Collectors run against the daemon
+	enabled []*collector
+	// Outputs of the daemon's metrics, updated after each of its collections
+	snapshots []*snapshot
+	// The collectors' series are shared with other daemons and dropped
+	// before each collection
+	resetMetrics bool
+}
+
+func loadEndpoints(path string) ([]endpointConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading endpoints: %w", err)
+	}
+	var endpoints []endpointConfig
+	if err := yaml.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("error parsing endpoints %s: %w", path, err)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints in %s", path)
+	}
+	seen := map[string]bool{}
+	for _, endpoint := range endpoints {
+		if endpoint.Name == "" || endpoint.Host == "" {
+			return nil, fmt.Errorf("endpoints need a name and a host")
+		}
+		if seen[endpoint.Name] {
+			return nil, fmt.Errorf("duplicate endpoint %q", endpoint.Name)
+		}
+		seen[endpoint.Name] = true
+		if (endpoint.TLSCert == "") != (endpoint.TLSKey == "") {
+			return nil, fmt.Errorf("endpoint %q needs both tlsCert and tlsKey", endpoint.Name)
+		}
+	}
+	return endpoints, nil
+}
+
+func newDaemons(cli *client.Client, enabled []*collector) ([]*daemon, error) {
+	// The daemon of the environment is local, as it always was
+	if *endpointsFile == "" {
+		return []*daemon{{cli: cli, enabled: enabled}}, nil
+	}
+
+	endpoints, err := loadEndpoints(*endpointsFile)
+	if err != nil {
+		return nil, err
+	}
+	var daemons []*daemon
+	for i, endpoint := range endpoints {
+		opts := []client.Opt{client.WithHost(endpoint.Host), client.WithAPIVersionNegotiation()}
+		if endpoint.TLSCACert != "" || endpoint.TLSCert != "" {
+			opts = append(opts, client.WithTLSClientConfig(endpoint.TLSCACert, endpoint.TLSCert, endpoint.TLSKey))
+		}
+		endpointCli, err := client.NewClientWithOpts(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating client of endpoint %q: %w", endpoint.Name, err)
+		}
+		daemons = append(daemons, &daemon{
+			name:         endpoint.Name,
+			cli:          endpointCli,
+			enabled:      daemonCollectors(enabled, i == 0, endpoint.Local),
+			resetMetrics: len(endpoints) > 1,
+		})
+	}
+	return daemons, nil
+}
+
+func daemonCollectors(enabled []*collector, primary, local bool) []*collector {
+	// Event streams, background trackers and state kept across cycles are
+	// those of the first daemon, host files those of local daemons
+	var collectors []*collector
+	for _, c := range enabled {
+		if !primary && !c.shared() {
+			continue
+		}
+		if !local && c.hostFiles {
+			continue
+		}
+		collectors = append(collectors, c)
+	}
+	return collectors
+}
+
+func (d *daemon) endpoint() string {
+	if d.name != "" {
+		return d.name
+	}
+	return d.cli.DaemonHost()
+}
+
+// snapshot returns a snapshot of the daemon's metrics from the gatherer,
+// updated after each collection of the daemon
+func (d *daemon) snapshot(gatherer prometheus.Gatherer) *snapshot {
+	if d.name != "" {
+		gatherer = endpointGatherer(gatherer, d.name)
+	}
+	s := newSnapshot(gatherer)
+	d.snapshots = append(d.snapshots, s)
+	return s
+}
+
+func (d *daemon) collect(ctx context.Context) {
+	start := time.Now()
+	// Collectors returning early would otherwise leave the series of the
+	// daemon collected before in this one's snapshot
+	if d.resetMetrics {
+		for _, c := range d.enabled {
+			if c.shared() {
+				c.resetMetrics()
+			}
+		}
+	}
+
+	// A daemon that doesn't answer at all is skipped and exports no series
+	// of its containers until it's back
+	if _, err := d.cli.Ping(ctx); err != nil {
+		logger.Error("Error pinging Docker daemon, skipping it", zap.String("endpoint", d.endpoint()), zap.Error(err))
+		scrapeErrors.WithLabelValues(d.endpoint()).Inc()
+		for _, c := range d.enabled {
+			if c.shared() {
+				c.resetMetrics()
+			}
+		}
+	} else {
+		collectDockerMetrics(ctx, d.cli, d.enabled)
+	}
+	scrapeDuration.WithLabelValues(d.endpoint()).Set(time.Since(start).Seconds())
+	for _, s := range d.snapshots {
+		s.update()
+	}
+}
+
+func daemonsGatherer(daemons []*daemon, gatherer func(d *daemon, primary bool) prometheus.Gatherer) prometheus.Gatherer {
+	if len(daemons) == 1 {
+		return daemons[0].snapshot(gatherer(daemons[0], true))
+	}
+	var gatherers prometheus.Gatherers
+	for i, d := range daemons {
+		gatherers = append(gatherers, d.snapshot(gatherer(d, i == 0)))
+	}
+	return gatherers
+}
+
+func endpointGatherer(gatherer prometheus.Gatherer, endpoint string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				// The scrape metrics of the daemon are labelled already
+				if metricHasLabel(m, endpointLabel) {
+					continue
+				}
+				name, value := endpointLabel, endpoint
+				m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+				sort.Slice(m.Label, func(i, j int) bool {
+					return m.Label[i].GetName() < m.Label[j].GetName()
+				})
+			}
+		}
+		return families, err
+	})
+}
+
+func metricHasLabel(m *dto.Metric, name string) bool {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scrapeCollector exports the snapshots of the daemons, collecting first
+// when the last collection is older than the max age; concurrent scrapes
+// share a collection
+type scrapeCollector struct {
+	gatherer prometheus.Gatherer
+	collect  func()
+	maxAge   time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// snapshotMetric is a series of a snapshot, as gathered
+type snapshotMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func newScrapeCollector(gatherer prometheus.Gatherer, collect func(), maxAge time.Duration) *scrapeCollector {
+	return &scrapeCollector{gatherer: gatherer, collect: collect, maxAge: maxAge}
+}
+
+// refresh collects unless the last collection is within the max age, 0
+// always collects
+func (c *scrapeCollector) refresh(maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.last.IsZero() && time.Since(c.last) < maxAge {
+		return
+	}
+	c.collect()
+	c.last = time.Now()
+}
+
+// Describe sends nothing, the series are only known once collected, which
+// makes the collector unchecked
+func (c *scrapeCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.refresh(c.maxAge)
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("docker_exporter_error", "Error gathering the collected metrics", nil, nil), err)
+	}
+	for _, mf := range families {
+		desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), nil, nil)
+		for _, m := range mf.GetMetric() {
+			ch <- snapshotMetric{desc: desc, metric: m}
+		}
+	}
+}
+
+func (m snapshotMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m snapshotMetric) Write(out *dto.Metric) error {
+	// The registry infers the type from the value set
+	out.Label = m.metric.Label
+	out.Gauge = m.metric.Gauge
+	out.Counter = m.metric.Counter
+	out.Summary = m.metric.Summary
+	out.Untyped = m.metric.Untyped
+	out.Histogram = m.metric.Histogram
+	out.TimestampMs = m.metric.TimestampMs
+	return nil
+}
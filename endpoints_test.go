@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLoadEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []string // endpoint names
+		wantErr string
+	}{
+		{
+			name: "valid",
+			config: `
+- name: local
+  host: unix:///var/run/docker.sock
+  local: true
+- name: remote
+  host: tcp://remote:2376
+  tlsCACert: ca.pem
+  tlsCert: cert.pem
+  tlsKey: key.pem
+`,
+			want: []string{"local", "remote"},
+		},
+		{
+			name:    "empty",
+			config:  "[]",
+			wantErr: "no endpoints",
+		},
+		{
+			name:    "invalid YAML",
+			config:  "name: local",
+			wantErr: "error parsing endpoints",
+		},
+		{
+			name:    "missing name",
+			config:  "- host: tcp://remote:2376",
+			wantErr: "need a name and a host",
+		},
+		{
+			name:    "missing host",
+			config:  "- name: remote",
+			wantErr: "need a name and a host",
+		},
+		{
+			name: "duplicate name",
+			config: `
+- name: remote
+  host: tcp://a:2376
+- name: remote
+  host: tcp://b:2376
+`,
+			wantErr: `duplicate endpoint "remote"`,
+		},
+		{
+			name: "cert without key",
+			config: `
+- name: remote
+  host: tcp://remote:2376
+  tlsCert: cert.pem
+`,
+			wantErr: "needs both tlsCert and tlsKey",
+		},
+		{
+			name: "key without cert",
+			config: `
+- name: remote
+  host: tcp://remote:2376
+  tlsKey: key.pem
+`,
+			wantErr: "needs both tlsCert and tlsKey",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "endpoints.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			endpoints, err := loadEndpoints(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, endpoint := range endpoints {
+				names = append(names, endpoint.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got endpoints %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointGatherer(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name: "no labels",
+			want: `endpoint="remote"`,
+		},
+		{
+			name:   "sorted in",
+			labels: map[string]string{"container_name": "/app", "image": "app:1"},
+			want:   `container_name="/app",endpoint="remote",image="app:1"`,
+		},
+		{
+			name:   "already labelled",
+			labels: map[string]string{"endpoint": "other"},
+			want:   `endpoint="other"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			family := &dto.MetricFamily{
+				Name:   proto.String("docker_test"),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+			}
+			for name, value := range tt.labels {
+				family.Metric[0].Label = append(family.Metric[0].Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+			}
+			gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return []*dto.MetricFamily{family}, nil
+			})
+
+			families, err := endpointGatherer(gatherer, "remote").Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var labels []string
+			for _, label := range families[0].Metric[0].Label {
+				labels = append(labels, label.GetName()+`="`+label.GetValue()+`"`)
+			}
+			if got := strings.Join(labels, ","); got != tt.want {
+				t.Errorf("got labels %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+const (
+	// Bounds of the delay before reconnecting to the event stream
+	minEventsBackoff = time.Second
+	maxEventsBackoff = time.Minute
+)
+
+var (
+	// Event stream state for the state dump
+	eventsConnected atomic.Bool
+	lastEventAt     atomic.Int64
+)
+
+func watchEvents(ctx context.Context, cli *client.Client, watchers []*collector) {
+	backoff := minEventsBackoff
+	for {
+		logger.Debug("Subscribing to Docker events")
+		connectedAt := time.Now()
+		msgs, errs := cli.Events(ctx, events.ListOptions{})
+		eventsConnected.Store(true)
+
+	stream:
+		for {
+			select {
+			case msg := <-msgs:
+				lastEventAt.Store(msg.TimeNano)
+				for _, c := range watchers {
+					c.handleEvent(msg)
+				}
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Error("Error reading Docker events", zap.Error(err))
+				eventsConnected.Store(false)
+				break stream
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// Reset the backoff once the stream stayed up for a while
+		if time.Since(connectedAt) > maxEventsBackoff {
+			backoff = minEventsBackoff
+		}
+		logger.Debug("Reconnecting to Docker events", zap.Duration("backoff", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxEventsBackoff)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	containerExecSessions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_exec_sessions",
+			Help: "Number of docker exec sessions running in the container",
+		},
+		[]string{"container_name"},
+	)
+	containerExecs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_container_execs_total",
+			Help: "Number of docker exec sessions started in the container",
+		},
+		[]string{"container_name"},
+	)
+
+	// Running exec sessions, exec ID -> container name
+	execMu       sync.Mutex
+	execSessions = map[string]string{}
+)
+
+func init() {
+	c := registerCollector("exec", collectExecMetrics, containerExecSessions, containerExecs)
+	c.handleEvent = handleExecEvent
+	c.dumpState = func() map[string]any {
+		execMu.Lock()
+		defer execMu.Unlock()
+		return map[string]any{"sessions": len(execSessions)}
+	}
+}
+
+func handleExecEvent(msg events.Message) {
+	if msg.Type != events.ContainerEventType {
+		return
+	}
+
+	execMu.Lock()
+	defer execMu.Unlock()
+
+	// Exec actions carry the command, as in "exec_start: sh -c ls"
+	execID := msg.Actor.Attributes["execID"]
+	action, _, _ := strings.Cut(string(msg.Action), ":")
+	switch events.Action(action) {
+	case events.ActionExecStart:
+		containerName := eventContainerName(msg)
+		containerExecs.WithLabelValues(containerName).Inc()
+		// Keep the gauge current between collections
+		if _, ok := execSessions[execID]; !ok {
+			execSessions[execID] = containerName
+			containerExecSessions.WithLabelValues(containerName).Inc()
+		}
+	case events.ActionExecDie:
+		if containerName, ok := execSessions[execID]; ok {
+			delete(execSessions, execID)
+			containerExecSessions.WithLabelValues(containerName).Dec()
+		}
+	}
+}
+
+func collectExecMetrics(ctx context.Context, cli *client.Client) {
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	// Inspect is authoritative, it catches sessions started before the exporter
+	// and ends missed while the event stream was down
+	running := map[string]string{}
+	for _, container := range containers {
+		containerName := container.Names[0]
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+		for _, execID := range info.ExecIDs {
+			exec, err := cli.ContainerExecInspect(ctx, execID)
+			if err != nil {
+				logger.Debug("Error inspecting exec session", zap.String("containerName", containerName), zap.Error(err))
+				continue
+			}
+			if exec.Running {
+				running[execID] = containerName
+			}
+		}
+	}
+
+	execMu.Lock()
+	defer execMu.Unlock()
+	execSessions = running
+
+	// Clear old metrics to avoid duplicates
+	containerExecSessions.Reset()
+	for _, container := range containers {
+		containerExecSessions.WithLabelValues(container.Names[0]).Set(0)
+	}
+	for _, containerName := range execSessions {
+		containerExecSessions.WithLabelValues(containerName).Inc()
+	}
+}
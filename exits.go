@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Classes of container exits
+	exitClassOK     = "ok"
+	exitClassError  = "error"
+	exitClassOOM    = "oom"
+	exitClassSignal = "signal"
+)
+
+var (
+	containerExits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_container_exits_total",
+			Help: "Number of container exits by class: ok, error, oom or signal",
+		},
+		[]string{"container_name", "service", "exit_class"},
+	)
+
+	// Containers that were OOM killed and haven't reported their exit yet
+	oomMu     sync.Mutex
+	oomKilled = map[string]bool{}
+)
+
+func init() {
+	c := registerCollector("exits", nil, containerExits)
+	c.handleEvent = handleExitEvent
+	c.dumpState = func() map[string]any {
+		oomMu.Lock()
+		defer oomMu.Unlock()
+		return map[string]any{"oomPending": len(oomKilled)}
+	}
+}
+
+func handleExitEvent(msg events.Message) {
+	if msg.Type != events.ContainerEventType {
+		return
+	}
+
+	oomMu.Lock()
+	defer oomMu.Unlock()
+
+	switch msg.Action {
+	case events.ActionOOM:
+		// The oom event comes right before the die event of the same container
+		oomKilled[msg.Actor.ID] = true
+	case events.ActionDie:
+		exitCode, _ := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+		containerExits.WithLabelValues(eventContainerName(msg), eventServiceName(msg), exitClass(exitCode, oomKilled[msg.Actor.ID])).Inc()
+		delete(oomKilled, msg.Actor.ID)
+	case events.ActionDestroy:
+		delete(oomKilled, msg.Actor.ID)
+	}
+}
+
+func exitClass(exitCode int, oom bool) string {
+	switch {
+	case oom:
+		return exitClassOOM
+	case exitCode == 0:
+		return exitClassOK
+	case exitCode > 128:
+		// Shells report death by signal N as 128+N
+		return exitClassSignal
+	default:
+		return exitClassError
+	}
+}
+
+func eventServiceName(msg events.Message) string {
+	// Container labels are part of the event attributes
+	if service := msg.Actor.Attributes["com.docker.swarm.service.name"]; service != "" {
+		return service
+	}
+	return msg.Actor.Attributes["com.docker.compose.service"]
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// File layouts for the metrics file output
+	layoutSingle       = "single"
+	layoutPerCollector = "per-collector"
+
+	defaultFileName  = "docker_metrics.prom"
+	runtimeFileName  = "docker_runtime.prom"
+	exporterFileName = "docker_exporter.prom"
+
+	// Handling of metric names clashing with other files in the directory
+	clashWarn   = "warn"
+	clashRefuse = "refuse"
+	clashIgnore = "ignore"
+
+	// Bounds of the backoff after the filesystem rejected a write
+	minWriteBackoff = 10 * time.Second
+	maxWriteBackoff = 5 * time.Minute
+)
+
+var (
+	fileWriteErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prom_file_write_errors_total",
+			Help: "Number of failed metrics file writes",
+		},
+		[]string{"reason"},
+	)
+	fileMetricClashes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_file_metric_clash",
+			Help: "Metric also written to another .prom file in the metrics directory",
+		},
+		[]string{"metric", "file"},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(fileWriteErrors, fileMetricClashes)
+}
+
+// fileOutput holds the settings for writing metrics files
+type fileOutput struct {
+	dir        string
+	layout     string
+	name       string
+	mode       os.FileMode
+	uid        int // -1 leaves the owner unchanged
+	gid        int // -1 leaves the group unchanged
+	timestamps bool
+	meta       bool
+	clashes    string
+
+	// Metric names of files written by other producers, by file
+	foreign map[string]foreignFile
+	// Clashes found by the last write, only new ones are logged
+	clashed map[string]string
+
+	// Write backoff state after ENOSPC/EROFS, read by the state dump while
+	// the writer runs
+	mu      sync.Mutex
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// foreignFile is a .prom file of another producer, reparsed when it changes
+type foreignFile struct {
+	modTime time.Time
+	size    int64
+	metrics []string
+}
+
+func newFileOutput(dir, layout, name, mode, owner, group, clashes string, timestamps, meta bool) (*fileOutput, error) {
+	out := &fileOutput{dir: dir, layout: layout, name: name, uid: -1, gid: -1, timestamps: timestamps, meta: meta, clashes: clashes, foreign: map[string]foreignFile{}}
+
+	switch clashes {
+	case clashWarn, clashRefuse, clashIgnore:
+	default:
+		return nil, fmt.Errorf("invalid metrics file clash handling %q", clashes)
+	}
+
+	if name == "" || filepath.Base(name) != name {
+		return nil, fmt.Errorf("invalid metrics file name %q", name)
+	}
+
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return nil, fmt.Errorf("invalid metrics file mode %q", mode)
+	}
+	out.mode = os.FileMode(perm)
+
+	// Ownership can only be changed when running as root
+	if (owner != "" || group != "") && os.Geteuid() != 0 {
+		logger.Warn("Not running as root, ignoring metrics file owner and group", zap.String("owner", owner), zap.String("group", group))
+		return out, nil
+	}
+	if owner != "" {
+		if out.uid, err = lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("error looking up metrics file owner: %w", err)
+		}
+	}
+	if group != "" {
+		if out.gid, err = lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return nil, fmt.Errorf("error looking up metrics file group: %w", err)
+		}
+	}
+	return out, nil
+}
+
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	// Accept numeric IDs as-is, they don't need to exist in /etc/passwd
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+func collectorFileName(name string) string {
+	return "docker_" + name + ".prom"
+}
+
+// ownedFileNames lists every file the exporter may write, used to clean up
+// files that are no longer produced
+func (o *fileOutput) ownedFileNames() []string {
+	names := []string{o.name, defaultFileName, runtimeFileName, exporterFileName}
+	for _, c := range allCollectors {
+		names = append(names, collectorFileName(c.name))
+	}
+	metas := make([]string, len(names))
+	for i, name := range names {
+		metas[i] = metaFileName(name)
+	}
+	return append(names, metas...)
+}
+
+func (o *fileOutput) files(enabled []*collector, exporter, runtimeMetrics bool) (map[string]prometheus.Gatherer, error) {
+	files := map[string]prometheus.Gatherer{}
+	switch o.layout {
+	case layoutSingle:
+		files[o.name] = collectorsGatherer(enabled, exporter, runtimeMetrics)
+	case layoutPerCollector:
+		for _, c := range enabled {
+			files[collectorFileName(c.name)] = processGatherer(c.registry)
+		}
+		if exporter {
+			files[exporterFileName] = processGatherer(prometheus.Gatherers{collectionRegistry, exporterRegistry})
+		} else {
+			files[exporterFileName] = processGatherer(collectionRegistry)
+		}
+		if runtimeMetrics {
+			files[runtimeFileName] = processGatherer(runtimeRegistry)
+		}
+	default:
+		return nil, fmt.Errorf("unknown file layout %q", o.layout)
+	}
+	return files, nil
+}
+
+func (o *fileOutput) write(files map[string]prometheus.Gatherer, collectedAt time.Time) error {
+	// Skip writing while backing off from a full or read-only filesystem
+	if collectedAt.Before(o.retryAt) {
+		logger.Debug("Skipping metrics file write while backing off", zap.Time("retryAt", o.retryAt))
+		return nil
+	}
+
+	clashes := o.foreignMetrics()
+	found := map[string]string{}
+
+	var errs []error
+	for name, gatherer := range files {
+		if err := o.writeMetricsToFile(filepath.Join(o.dir, name), gatherer, collectedAt, clashes, found); err != nil {
+			fileWriteErrors.WithLabelValues(writeErrorReason(err)).Inc()
+			errs = append(errs, err)
+		}
+	}
+
+	// Set after the files are written, they show up in the next cycle
+	fileMetricClashes.Reset()
+	for metric, file := range found {
+		fileMetricClashes.WithLabelValues(metric, file).Set(1)
+	}
+	o.clashed = found
+	err := errors.Join(errs...)
+
+	// Back off exponentially when the filesystem can't take writes, retrying every cycle won't help
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch writeErrorReason(err) {
+	case "no_space", "read_only":
+		o.backoff = min(max(o.backoff*2, minWriteBackoff), maxWriteBackoff)
+		o.retryAt = collectedAt.Add(o.backoff)
+		logger.Warn("Backing off metrics file writes", zap.Duration("backoff", o.backoff), zap.Error(err))
+	default:
+		o.backoff = 0
+	}
+	return err
+}
+
+func (o *fileOutput) foreignMetrics() map[string]string {
+	// Metric name -> file of the other producers, a family present in two files
+	// fails the whole textfile collector scrape
+	clashes := map[string]string{}
+	if o.clashes == clashIgnore {
+		return clashes
+	}
+
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		logger.Error("Error listing metrics directory", zap.String("dir", o.dir), zap.Error(err))
+		return clashes
+	}
+	owned := map[string]bool{}
+	for _, name := range o.ownedFileNames() {
+		owned[name] = true
+	}
+
+	seen := map[string]foreignFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if owned[name] || entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".prom" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		file, ok := o.foreign[name]
+		if !ok || !file.modTime.Equal(info.ModTime()) || file.size != info.Size() {
+			file = foreignFile{modTime: info.ModTime(), size: info.Size()}
+			if file.metrics, err = readMetricNames(filepath.Join(o.dir, name)); err != nil {
+				logger.Debug("Error parsing metrics file of another producer", zap.String("file", name), zap.Error(err))
+			}
+		}
+		seen[name] = file
+		for _, metric := range file.metrics {
+			clashes[metric] = name
+		}
+	}
+	o.foreign = seen
+	return clashes
+}
+
+func readMetricNames(promFile string) ([]string, error) {
+	file, err := os.Open(promFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(file)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (o *fileOutput) checkClashes(metrics []*dto.MetricFamily, clashes, found map[string]string) []*dto.MetricFamily {
+	kept := metrics[:0]
+	for _, mf := range metrics {
+		file, ok := clashes[mf.GetName()]
+		if !ok {
+			kept = append(kept, mf)
+			continue
+		}
+		found[mf.GetName()] = file
+		known := o.clashed[mf.GetName()] == file
+		if o.clashes == clashRefuse {
+			if !known {
+				logger.Error("Leaving out metric also written by another producer", zap.String("metric", mf.GetName()), zap.String("file", file))
+			}
+			continue
+		}
+		if !known {
+			logger.Warn("Metric also written by another producer", zap.String("metric", mf.GetName()), zap.String("file", file))
+		}
+		kept = append(kept, mf)
+	}
+	return kept
+}
+
+func (o *fileOutput) backoffState() (time.Duration, time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.backoff, o.retryAt
+}
+
+func writeErrorReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return "no_space"
+	case errors.Is(err, syscall.EROFS):
+		return "read_only"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	default:
+		return "other"
+	}
+}
+
+func (o *fileOutput) writeMetricsToFile(promFile string, gatherer prometheus.Gatherer, collectedAt time.Time, clashes, found map[string]string) error {
+	// Gather metrics and encode in Prometheus text format
+	metrics, err := gatherer.Gather()
+	if err != nil {
+		logger.Error("Error gathering metrics", zap.Error(err))
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+	metrics = o.checkClashes(metrics, clashes, found)
+	// The families are those of the snapshot scrapes are served from at the
+	// same time, sort and stamp copies of them
+	for i, mf := range metrics {
+		metrics[i] = proto.Clone(mf).(*dto.MetricFamily)
+	}
+	sortMetrics(metrics)
+
+	// Stamp samples with the collection time so consumers know the data age,
+	// left unstamped unless timestamps were requested
+	if o.timestamps {
+		ts := collectedAt.UnixMilli()
+		for _, mf := range metrics {
+			for _, m := range mf.GetMetric() {
+				m.TimestampMs = &ts
+			}
+		}
+	}
+
+	// Write to a temp file first and rename it over the metrics file, so a
+	// failed write never truncates the previous good file
+	logger.Debug("Writing metrics to file", zap.String("file", promFile))
+	file, err := os.CreateTemp(filepath.Dir(promFile), "."+filepath.Base(promFile)+".tmp*")
+	if err != nil {
+		logger.Error("Error creating temporary metrics file", zap.Error(err))
+		return fmt.Errorf("error creating temporary metrics file: %w", err)
+	}
+	tmpFile := file.Name()
+	defer func() {
+		// Only left behind when something failed before the rename
+		if err := os.Remove(tmpFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Error removing temporary metrics file", zap.String("file", tmpFile), zap.Error(err))
+		}
+	}()
+
+	checksum, err := o.writeMetrics(file, metrics)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		logger.Error("Error closing metrics file", zap.Error(err))
+		return fmt.Errorf("error closing metrics file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile, promFile); err != nil {
+		logger.Error("Error renaming metrics file", zap.Error(err))
+		return fmt.Errorf("error renaming metrics file: %w", err)
+	}
+	logger.Debug("Metrics written to file")
+
+	if o.meta {
+		return o.writeMeta(promFile, newFileMeta(collectedAt, metrics, checksum))
+	}
+	return nil
+}
+
+func sortMetrics(metrics []*dto.MetricFamily) {
+	// Same order every cycle so the files can be diffed and checksummed, the
+	// renames of the metric overrides can reorder the families
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].GetName() < metrics[j].GetName()
+	})
+	for _, mf := range metrics {
+		for _, m := range mf.GetMetric() {
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+		sort.SliceStable(mf.Metric, func(i, j int) bool {
+			return compareLabels(mf.Metric[i].GetLabel(), mf.Metric[j].GetLabel()) < 0
+		})
+	}
+}
+
+func compareLabels(a, b []*dto.LabelPair) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i].GetName(), b[i].GetName()); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a[i].GetValue(), b[i].GetValue()); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func (o *fileOutput) writeMetrics(file *os.File, metrics []*dto.MetricFamily) (string, error) {
+	// Apply mode and ownership explicitly, the temp file is created with 0600
+	if err := file.Chmod(o.mode); err != nil {
+		logger.Error("Error setting metrics file mode", zap.Error(err))
+		return "", fmt.Errorf("error setting metrics file mode: %w", err)
+	}
+	if o.uid != -1 || o.gid != -1 {
+		if err := file.Chown(o.uid, o.gid); err != nil {
+			logger.Error("Error setting metrics file owner", zap.Error(err))
+			return "", fmt.Errorf("error setting metrics file owner: %w", err)
+		}
+	}
+
+	// Checksum of the content for the metadata file
+	hash := sha256.New()
+	encoder := expfmt.NewEncoder(io.MultiWriter(file, hash), PromText)
+	for _, metric := range metrics {
+		if err := encoder.Encode(metric); err != nil {
+			logger.Error("Error encoding metrics", zap.Error(err))
+			return "", fmt.Errorf("error encoding metrics: %w", err)
+		}
+	}
+
+	// Make sure the data hit the disk, ENOSPC may only surface here
+	if err := file.Sync(); err != nil {
+		logger.Error("Error syncing metrics file", zap.Error(err))
+		return "", fmt.Errorf("error syncing metrics file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (o *fileOutput) cleanup(keep map[string]prometheus.Gatherer) {
+	// Remove files left behind by disabled collectors or another layout
+	kept := map[string]bool{}
+	for name := range keep {
+		kept[name] = true
+		if o.meta {
+			kept[metaFileName(name)] = true
+		}
+	}
+	for _, name := range o.ownedFileNames() {
+		if kept[name] {
+			continue
+		}
+		promFile := filepath.Join(o.dir, name)
+		if err := os.Remove(promFile); err == nil {
+			logger.Info("Removed stale metrics file", zap.String("file", promFile))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Error removing stale metrics file", zap.String("file", promFile), zap.Error(err))
+		}
+	}
+}
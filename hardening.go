@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	hardeningReadOnlyRoot   = "read_only_rootfs"
+	hardeningNonRoot        = "non_root"
+	hardeningCapabilities   = "capabilities"
+	capabilityDacReadSearch = 2
+	capabilitySysPtrace     = 19
+)
+
+var (
+	requireHardened = flag.Bool("requireHardened", false, "Refuse to start unless the exporter runs with a read-only root filesystem, as a non-root user and without unneeded capabilities")
+
+	hardeningCheck = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_hardening_check",
+			Help: "Whether the exporter passed the hardening check at startup (1) or not (0)",
+		},
+		[]string{"check"},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(hardeningCheck)
+}
+
+// hardeningResult is the outcome of a hardening check, with why it failed
+type hardeningResult struct {
+	check  string
+	passed bool
+	reason string
+}
+
+func checkHardening(enabled []*collector) error {
+	results := []hardeningResult{checkReadOnlyRoot(), checkNonRoot(), checkCapabilities(enabled)}
+
+	var failed []string
+	for _, r := range results {
+		if r.passed {
+			hardeningCheck.WithLabelValues(r.check).Set(1)
+			continue
+		}
+		hardeningCheck.WithLabelValues(r.check).Set(0)
+		logger.Warn("Exporter failed a hardening check", zap.String("check", r.check), zap.String("reason", r.reason))
+		failed = append(failed, r.check)
+	}
+	if len(failed) == 0 {
+		logger.Info("Exporter passed the hardening checks")
+		return nil
+	}
+	return fmt.Errorf("failed hardening checks: %s", strings.Join(failed, ", "))
+}
+
+func checkReadOnlyRoot() hardeningResult {
+	result := hardeningResult{check: hardeningReadOnlyRoot}
+	var stat unix.Statfs_t
+	if err := unix.Statfs("/", &stat); err != nil {
+		result.reason = fmt.Sprintf("error getting root filesystem flags: %v", err)
+		return result
+	}
+	result.passed = stat.Flags&unix.ST_RDONLY != 0
+	if !result.passed {
+		result.reason = "root filesystem is writable"
+	}
+	return result
+}
+
+func checkNonRoot() hardeningResult {
+	result := hardeningResult{check: hardeningNonRoot}
+	result.passed = os.Geteuid() != 0
+	if !result.passed {
+		result.reason = "running as root"
+	}
+	return result
+}
+
+func checkCapabilities(enabled []*collector) hardeningResult {
+	result := hardeningResult{check: hardeningCapabilities}
+	effective, err := effectiveCapabilities()
+	if err != nil {
+		result.reason = fmt.Sprintf("error reading capabilities: %v", err)
+		return result
+	}
+
+	// Collectors reading host files need to get past the permissions of
+	// other users' files and processes, nothing else needs any
+	var needed uint64
+	for _, c := range enabled {
+		if c.hostFiles {
+			needed = 1<<capabilityDacReadSearch | 1<<capabilitySysPtrace
+			break
+		}
+	}
+	if unneeded := effective &^ needed; unneeded != 0 {
+		result.reason = fmt.Sprintf("unneeded effective capabilities %#x", unneeded)
+		return result
+	}
+	result.passed = true
+	return result
+}
+
+func effectiveCapabilities() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in /proc/self/status")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Time allowed for each heartbeat request
+	heartbeatTimeout  = 10 * time.Second
+	minHeartbeatRetry = 1 * time.Second
+	maxHeartbeatRetry = 1 * time.Minute
+)
+
+var (
+	heartbeatURL      = flag.String("heartbeatURL", "", "Inventory URL the host identity, exporter version and container count are POSTed to as JSON every heartbeatInterval")
+	heartbeatInterval = flag.Duration("heartbeatInterval", 5*time.Minute, "How often the heartbeat is sent")
+
+	heartbeatLastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_heartbeat_last_success_timestamp_seconds",
+			Help: "Time the inventory last accepted a heartbeat",
+		},
+	)
+	heartbeatFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "docker_prom_heartbeat_failures_total",
+			Help: "Number of heartbeat requests the inventory didn't accept",
+		},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(heartbeatLastSuccess, heartbeatFailures)
+}
+
+// heartbeat is the body POSTed to the inventory
+type heartbeat struct {
+	Hostname          string            `json:"hostname"`
+	MachineID         string            `json:"machineID,omitempty"`
+	DaemonID          string            `json:"daemonID,omitempty"`
+	DockerVersion     string            `json:"dockerVersion,omitempty"`
+	ExporterVersion   string            `json:"exporterVersion"`
+	Containers        int               `json:"containers"`
+	ContainersRunning int               `json:"containersRunning"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	SentAt            time.Time         `json:"sentAt"`
+}
+
+func runHeartbeat(ctx context.Context, cli *client.Client) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Error("Error getting host name for heartbeat", zap.Error(err))
+	}
+	// Stable across renames and reinstalls of the exporter
+	machineID, err := os.ReadFile(hostPath("/etc/machine-id"))
+	if err != nil {
+		logger.Debug("Error reading machine ID for heartbeat", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(*heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		beat := heartbeat{Hostname: hostname, MachineID: strings.TrimSpace(string(machineID)), ExporterVersion: version()}
+		// Sent without the daemon details when it doesn't answer, the host
+		// is known to the inventory all the same
+		if info, err := cli.Info(ctx); err != nil {
+			logger.Error("Error getting Docker info for heartbeat", zap.Error(err))
+		} else {
+			beat.DaemonID = info.ID
+			beat.DockerVersion = info.ServerVersion
+			beat.Containers = info.Containers
+			beat.ContainersRunning = info.ContainersRunning
+		}
+		hostMetadataMu.RLock()
+		beat.Metadata = maps.Clone(hostMetadata)
+		hostMetadataMu.RUnlock()
+
+		sendHeartbeat(ctx, beat, time.Now().Add(*heartbeatInterval))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendHeartbeat(ctx context.Context, beat heartbeat, next time.Time) {
+	backoff := minHeartbeatRetry
+	for {
+		beat.SentAt = time.Now().UTC()
+		err := postHeartbeat(ctx, beat)
+		if err == nil {
+			heartbeatLastSuccess.SetToCurrentTime()
+			logger.Debug("Heartbeat sent", zap.String("url", *heartbeatURL))
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		heartbeatFailures.Inc()
+		// A fresh heartbeat replaces the retries once it's due
+		if time.Now().Add(backoff).After(next) {
+			logger.Error("Error sending heartbeat", zap.String("url", *heartbeatURL), zap.Error(err))
+			return
+		}
+		logger.Error("Error sending heartbeat", zap.String("url", *heartbeatURL), zap.Error(err), zap.Duration("retryIn", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxHeartbeatRetry)
+	}
+}
+
+func postHeartbeat(ctx context.Context, beat heartbeat) error {
+	body, err := json.Marshal(beat)
+	if err != nil {
+		return fmt.Errorf("error encoding heartbeat: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *heartbeatURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating heartbeat request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("inventory answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	usageHistograms = flag.Bool("usageHistograms", false, "Export histograms of the CPU and memory used by the running containers, for distributions without per container series")
+
+	containerCPUDistribution = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "docker_containers_cpu_cores_distribution",
+			Help:    "Distribution of the CPU used since the last collection in cores, over the running containers",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+		},
+		[]string{},
+	)
+	containerMemoryDistribution = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "docker_containers_memory_bytes_distribution",
+			Help:    "Distribution of the memory used without reclaimable page cache, over the running containers",
+			Buckets: prometheus.ExponentialBuckets(16<<20, 2, 12),
+		},
+		[]string{},
+	)
+)
+
+func setUsageHistograms(usages []containerUsage) {
+	if !*usageHistograms {
+		return
+	}
+
+	// Clear old metrics, the histograms show the current containers only
+	containerCPUDistribution.Reset()
+	containerMemoryDistribution.Reset()
+
+	cpu := containerCPUDistribution.WithLabelValues()
+	memory := containerMemoryDistribution.WithLabelValues()
+	for _, usage := range usages {
+		cpu.Observe(usage.cores)
+		memory.Observe(float64(usage.memory))
+	}
+}
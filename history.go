@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"slices"
+	"strconv"
+	"strings"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	baseImages         = flag.String("baseImages", "", "Comma separated list of known base images (e.g. alpine:3.20,debian:bookworm-slim) to detect in locally built images and containers")
+	approvedBaseImages = flag.String("approvedBaseImages", "", "Comma separated list of approved base images, detected like baseImages and reported as approved")
+
+	// Build history of images built on the host
+	imageBuildTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_build_timestamp_seconds",
+			Help: "Creation time of the locally built image",
+		},
+		[]string{"image_id", "image_repo"},
+	)
+	imageHistoryLayers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_history_layers",
+			Help: "Number of history entries of the locally built image",
+		},
+		[]string{"image_id", "image_repo"},
+	)
+	imageBaseImageInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_base_image_info",
+			Help: "Known base image the locally built image was built from, empty when none matched",
+		},
+		[]string{"image_id", "image_repo", "base_image"},
+	)
+	containerBaseImageInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_base_image_info",
+			Help: "Known base image the image of the container was built from, empty when none matched",
+		},
+		[]string{"container_name", "base_image", "approved"},
+	)
+
+	historyGauges = []*prometheus.GaugeVec{
+		imageBuildTimestamp,
+		imageHistoryLayers,
+		imageBaseImageInfo,
+		containerBaseImageInfo,
+	}
+)
+
+func init() {
+	registerCollector("history", collectHistoryMetrics, imageBuildTimestamp, imageHistoryLayers, imageBaseImageInfo, containerBaseImageInfo)
+}
+
+func collectHistoryMetrics(ctx context.Context, cli *client.Client) {
+	images, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing images", zap.Error(err))
+		return
+	}
+
+	// Clear old metrics to avoid duplicates
+	for _, g := range historyGauges {
+		g.Reset()
+	}
+
+	approved := splitList(*approvedBaseImages)
+	bases := baseImageLayers(ctx, cli, append(splitList(*baseImages), approved...))
+
+	for _, img := range images {
+		// Images with a repo digest were pulled or pushed, not built on this host
+		if len(img.RepoDigests) > 0 {
+			continue
+		}
+
+		imageRepo := "unknown"
+		if len(img.RepoTags) > 0 {
+			imageRepo = img.RepoTags[0]
+		}
+		imageBuildTimestamp.WithLabelValues(img.ID, imageRepo).Set(float64(img.Created))
+
+		history, err := cli.ImageHistory(ctx, img.ID)
+		if err != nil {
+			logger.Error("Error getting image history", zap.String("image", imageRepo), zap.Error(err))
+		} else {
+			imageHistoryLayers.WithLabelValues(img.ID, imageRepo).Set(float64(len(history)))
+		}
+
+		if len(bases) == 0 {
+			continue
+		}
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, img.ID)
+		if err != nil {
+			logger.Error("Error inspecting image", zap.String("image", imageRepo), zap.Error(err))
+			continue
+		}
+		imageBaseImageInfo.WithLabelValues(img.ID, imageRepo, matchBaseImage(inspect.RootFS.Layers, bases)).Set(1)
+	}
+
+	if len(bases) > 0 {
+		setContainerBaseImageMetrics(ctx, cli, bases, approved)
+	}
+}
+
+func setContainerBaseImageMetrics(ctx context.Context, cli *client.Client, bases map[string][]string, approved []string) {
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	// Containers of the same image share its base
+	matches := map[string]string{}
+	for _, container := range containers {
+		containerName := container.Names[0]
+		match, ok := matches[container.ImageID]
+		if !ok {
+			inspect, _, err := cli.ImageInspectWithRaw(ctx, container.ImageID)
+			if err != nil {
+				logger.Error("Error inspecting image for container", zap.String("containerName", containerName), zap.Error(err))
+				setCollectionError(containerName, stageImageInspect, err)
+				continue
+			}
+			match = matchBaseImage(inspect.RootFS.Layers, bases)
+			matches[container.ImageID] = match
+		}
+		containerBaseImageInfo.WithLabelValues(containerName, match, strconv.FormatBool(slices.Contains(approved, match))).Set(1)
+	}
+}
+
+func baseImageLayers(ctx context.Context, cli *client.Client, refs []string) map[string][]string {
+	// Base images have to be present locally to compare layers against
+	bases := map[string][]string{}
+	for _, ref := range refs {
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		if err != nil {
+			logger.Debug("Base image not available locally", zap.String("image", ref), zap.Error(err))
+			continue
+		}
+		bases[ref] = inspect.RootFS.Layers
+	}
+	return bases
+}
+
+func matchBaseImage(layers []string, bases map[string][]string) string {
+	// The most specific base, i.e. the one sharing the most layers, wins
+	match := ""
+	matchLen := 0
+	for ref, baseLayers := range bases {
+		if len(baseLayers) == 0 || len(baseLayers) > len(layers) || len(baseLayers) <= matchLen {
+			continue
+		}
+		if isLayerPrefix(baseLayers, layers) {
+			match, matchLen = ref, len(baseLayers)
+		}
+	}
+	return match
+}
+
+func isLayerPrefix(prefix, layers []string) bool {
+	for i := range prefix {
+		if prefix[i] != layers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
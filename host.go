@@ -0,0 +1,15 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+)
+
+var (
+	hostRoot = flag.String("hostRoot", "/", "Host root filesystem as seen by the exporter, e.g. /host when running in a container with / mounted there")
+)
+
+func hostPath(path string) string {
+	// Paths reported by the daemon are host paths
+	return filepath.Join(*hostRoot, path)
+}
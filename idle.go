@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	idleTimeout = flag.Duration("idleTimeout", 0, "Exit after no HTTP request was served for this long, for systemd socket activation starting the exporter on the next scrape (0 to never exit)")
+
+	// Unix nanoseconds of the last HTTP request, or of the start
+	lastActivity atomic.Int64
+)
+
+func trackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastActivity.Store(time.Now().UnixNano())
+		next.ServeHTTP(w, r)
+	})
+}
+
+func watchIdle(ctx context.Context, timeout time.Duration, exit func()) {
+	// The scrape that started the exporter counts as activity
+	lastActivity.Store(time.Now().UnixNano())
+
+	ticker := time.NewTicker(min(timeout/4, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		idle := time.Since(time.Unix(0, lastActivity.Load()))
+		if idle >= timeout {
+			logger.Info("Exiting after idle timeout", zap.Duration("idle", idle))
+			exit()
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	imageLabelKeys      = flag.String("imageLabels", "", "Comma separated list of image label keys (e.g. license,maintainer) to export")
+	requiredImageLabels = flag.String("requiredImageLabels", "", "Comma separated list of image label keys every image must have")
+
+	imageLabelInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_label_info",
+			Help: "Value of the exported label of the local image",
+		},
+		[]string{"image_id", "image_repo", "label", "value"},
+	)
+	imageLabelMissing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_required_label_missing",
+			Help: "Whether the local image lacks the required label",
+		},
+		[]string{"image_id", "image_repo", "label"},
+	)
+	imageMissingLabels = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_missing_required_labels",
+			Help: "Number of required labels the local image lacks",
+		},
+		[]string{"image_id", "image_repo"},
+	)
+
+	imageLabelGauges = []*prometheus.GaugeVec{imageLabelInfo, imageLabelMissing, imageMissingLabels}
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range imageLabelGauges {
+		metrics = append(metrics, g)
+	}
+	registerCollector("imagelabels", collectImageLabelMetrics, metrics...)
+}
+
+func collectImageLabelMetrics(ctx context.Context, cli *client.Client) {
+	images, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing images", zap.Error(err))
+		return
+	}
+
+	// Clear old metrics to avoid duplicates
+	for _, g := range imageLabelGauges {
+		g.Reset()
+	}
+
+	keys := splitList(*imageLabelKeys)
+	required := splitList(*requiredImageLabels)
+	for _, img := range images {
+		imageRepo := "unknown"
+		if len(img.RepoTags) > 0 {
+			imageRepo = img.RepoTags[0]
+		}
+
+		// The image list carries the labels of the image config
+		for _, key := range keys {
+			if value, ok := img.Labels[key]; ok {
+				imageLabelInfo.WithLabelValues(img.ID, imageRepo, key, value).Set(1)
+			}
+		}
+
+		missing := 0
+		for _, key := range required {
+			value := 0.0
+			if img.Labels[key] == "" {
+				value = 1
+				missing++
+			}
+			imageLabelMissing.WithLabelValues(img.ID, imageRepo, key).Set(value)
+		}
+		if len(required) > 0 {
+			imageMissingLabels.WithLabelValues(img.ID, imageRepo).Set(float64(missing))
+		}
+	}
+}
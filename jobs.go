@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Container label naming the batch job a container runs
+	jobLabel = "docker-prom.job"
+)
+
+var (
+	jobLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_job_last_success_timestamp_seconds",
+			Help: "Time the last successful run of the job ended",
+		},
+		[]string{"job"},
+	)
+	jobLastDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_job_last_duration_seconds",
+			Help: "Duration of the last run of the job",
+		},
+		[]string{"job"},
+	)
+	jobConsecutiveFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_job_consecutive_failures",
+			Help: "Number of failed runs of the job since the last success",
+		},
+		[]string{"job"},
+	)
+
+	// Job tracking state
+	jobsMu     sync.Mutex
+	jobStarts  = map[string]time.Time{} // container ID -> start time
+	jobFailing = map[string]int{}       // job -> consecutive failures
+)
+
+func init() {
+	c := registerCollector("jobs", nil, jobLastSuccess, jobLastDuration, jobConsecutiveFailures)
+	c.handleEvent = handleJobEvent
+	c.dumpState = func() map[string]any {
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		failing := map[string]int{}
+		for job, failures := range jobFailing {
+			if failures > 0 {
+				failing[job] = failures
+			}
+		}
+		return map[string]any{"running": len(jobStarts), "failingJobs": failing}
+	}
+}
+
+func handleJobEvent(msg events.Message) {
+	job := msg.Actor.Attributes[jobLabel]
+	if msg.Type != events.ContainerEventType || job == "" {
+		return
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	id := msg.Actor.ID
+	at := time.Unix(0, msg.TimeNano)
+	switch msg.Action {
+	case events.ActionStart:
+		jobStarts[id] = at
+	case events.ActionDie:
+		if started, ok := jobStarts[id]; ok {
+			jobLastDuration.WithLabelValues(job).Set(at.Sub(started).Seconds())
+			delete(jobStarts, id)
+		}
+
+		exitCode, _ := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+		if exitCode == 0 {
+			jobFailing[job] = 0
+			jobLastSuccess.WithLabelValues(job).Set(float64(at.Unix()))
+		} else {
+			jobFailing[job]++
+		}
+		jobConsecutiveFailures.WithLabelValues(job).Set(float64(jobFailing[job]))
+	case events.ActionDestroy:
+		delete(jobStarts, id)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// First file descriptor passed by systemd socket activation
+	activationFirstFD = 3
+)
+
+var (
+	listenAddress = flag.String("listenAddress", "", "Address to listen on for Prometheus metrics, e.g. :: or 2001:db8::1 (all IPv4 and IPv6 addresses when empty)")
+	listenNetwork = flag.String("listenNetwork", "tcp", "Listener network: tcp for dual-stack, tcp4 or tcp6 for a single family")
+)
+
+func listenAndServe(network, address, port string) error {
+	// A socket passed by systemd takes precedence over the listen flags
+	listener, err := activationListener()
+	if err != nil {
+		return err
+	}
+	if listener == nil {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return fmt.Errorf("invalid listen network %q", network)
+		}
+		// Bracketed IPv6 addresses as in URLs are accepted too
+		host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+		if listener, err = net.Listen(network, net.JoinHostPort(host, port)); err != nil {
+			return err
+		}
+	}
+	return http.Serve(listener, trackActivity(http.DefaultServeMux))
+}
+
+func activationListener() (net.Listener, error) {
+	// The sd_listen_fds protocol, the variables are meant for this process only
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds > 1 {
+		logger.Warn("Several sockets passed by systemd, only the first is used")
+	}
+
+	file := os.NewFile(activationFirstFD, "systemd socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("error using the socket passed by systemd: %w", err)
+	}
+	logger.Info("Listening on the socket passed by systemd", zap.String("address", listener.Addr().String()))
+	return listener, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	logDedupWindow = flag.Duration("logDedupWindow", 10*time.Minute, "Log identical errors once per window and summarize the repeats (0 disables)")
+
+	logErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prom_log_errors_total",
+			Help: "Number of errors logged by the exporter, including suppressed repeats",
+		},
+		[]string{"message"},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(logErrors)
+}
+
+// dedupState tracks the errors logged in the current window
+type dedupState struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+// dedupEntry is an error logged in the current window and its suppressed repeats
+type dedupEntry struct {
+	entry      zapcore.Entry
+	fields     []zapcore.Field
+	suppressed int
+}
+
+// dedupCore drops repeats of identical errors, where the summaries report them
+type dedupCore struct {
+	zapcore.Core
+	state  *dedupState
+	fields []zapcore.Field
+}
+
+func newDedupCore(core zapcore.Core, window time.Duration) zapcore.Core {
+	state := &dedupState{entries: map[string]*dedupEntry{}}
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		for range ticker.C {
+			state.summarize(core, window)
+		}
+	}()
+	return &dedupCore{Core: core, state: state}
+}
+
+func (c *dedupCore) With(fields []zapcore.Field) zapcore.Core {
+	return &dedupCore{
+		Core:   c.Core.With(fields),
+		state:  c.state,
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *dedupCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *dedupCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if entry.Level < zapcore.ErrorLevel {
+		return c.Core.Write(entry, fields)
+	}
+	logErrors.WithLabelValues(entry.Message).Inc()
+
+	// Identical means same message and field values, so errors of different
+	// containers are still logged
+	all := append(append([]zapcore.Field{}, c.fields...), fields...)
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range all {
+		field.AddTo(encoder)
+	}
+	key := entry.Message + fmt.Sprint(encoder.Fields)
+
+	c.state.mu.Lock()
+	if seen, ok := c.state.entries[key]; ok {
+		seen.suppressed++
+		c.state.mu.Unlock()
+		return nil
+	}
+	c.state.entries[key] = &dedupEntry{entry: entry, fields: all}
+	c.state.mu.Unlock()
+	return c.Core.Write(entry, fields)
+}
+
+func (s *dedupState) summarize(core zapcore.Core, window time.Duration) {
+	s.mu.Lock()
+	entries := s.entries
+	s.entries = map[string]*dedupEntry{}
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		if e.suppressed == 0 {
+			continue
+		}
+		summary := zapcore.Entry{
+			Level:   zapcore.WarnLevel,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("%s occurred %d times in last %s", e.entry.Message, e.suppressed+1, window),
+		}
+		if err := core.Write(summary, e.fields); err != nil {
+			fmt.Printf("Error writing log summary: %v", err)
+		}
+	}
+}
+
+func dedupLogs(logger *zap.Logger) *zap.Logger {
+	if *logDedupWindow <= 0 {
+		return logger
+	}
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newDedupCore(core, *logDedupWindow)
+	}))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"time"
+)
+
+const (
+	lowPowerSource = "low power profile"
+
+	// How often in-flight downloads are sampled in low power mode
+	lowPowerPullPollInterval = 10 * time.Second
+)
+
+var (
+	lowPower = flag.Bool("lowPower", false, "Low power profile for Raspberry Pi class hosts: longer intervals, no per container stats and fewer Docker calls")
+
+	// Defaults replaced by the low power profile, flags set explicitly are kept
+	lowPowerDefaults = map[string]string{
+		"interval":             "1m",
+		"distributionInterval": "6h",
+		"warmupConcurrency":    "1",
+	}
+)
+
+func applyLowPower() error {
+	if !*lowPower {
+		return nil
+	}
+	for name, value := range lowPowerDefaults {
+		if flagSources[name] != "default" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+		flagSources[name] = lowPowerSource
+	}
+	return nil
+}
+
+func lowPowerCollectors(enabled []*collector) []*collector {
+	if !*lowPower {
+		return enabled
+	}
+	// Stats cost a request and a JSON decode per container every cycle
+	return slices.DeleteFunc(enabled, func(c *collector) bool {
+		if c.name == "stats" {
+			logger.Info("Stats collector disabled in low power mode")
+			return true
+		}
+		return false
+	})
+}
@@ -5,14 +5,18 @@ import (
 	"flag"
 	"fmt"
 	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"go.uber.org/zap"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/expfmt"
 )
@@ -25,19 +29,77 @@ var (
 	// logger
 	logger *zap.Logger
 
+	// Registry for Go runtime and process metrics of the exporter itself
+	runtimeRegistry = prometheus.NewRegistry()
+
+	// Registry for the exporter's own docker_prom_* metrics, always exposed
+	exporterRegistry = prometheus.NewRegistry()
+
+	// Registry for metrics about a collection that aren't a collector's,
+	// exposed per daemon
+	collectionRegistry = prometheus.NewRegistry()
+
 	// Define Prometheus metric
 	containerImageInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "docker_container_image_info",
 			Help: "Docker container image information",
 		},
-		[]string{"container_name", "image_id", "image_repo"},
+		[]string{"container_name", "image_id", "image_repo", "registry"},
+	)
+	imageContainers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_containers",
+			Help: "Number of running containers using the local image",
+		},
+		[]string{"image_id", "image_repo", "registry"},
 	)
+	containerArchInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_arch_info",
+			Help: "Architecture of the container image and of the host",
+		},
+		[]string{"container_name", "image_arch", "host_arch"},
+	)
+	containerArchMismatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_arch_mismatch",
+			Help: "Whether the container image can't run natively on the host architecture and runs under emulation",
+		},
+		[]string{"container_name"},
+	)
+
+	imageInfoSeries      = newGaugeSeries(containerImageInfo)
+	imageContainerSeries = newGaugeSeries(imageContainers)
+	archInfoSeries       = newGaugeSeries(containerArchInfo)
+	archMismatchSeries   = newGaugeSeries(containerArchMismatch)
+
+	// Kernel machine names reported by the daemon, as image architectures
+	machineArchs = map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+		"armv7l":  "arm",
+		"armv6l":  "arm",
+		"i386":    "386",
+		"i686":    "386",
+	}
+
+	// Image architectures hosts run natively besides their own
+	nativeArchs = map[string][]string{
+		"amd64": {"386"},
+		"arm64": {"arm"},
+	}
 )
 
 func init() {
-	// Register the Prometheus metric
-	prometheus.MustRegister(containerImageInfo)
+	// Register the image collector and its metric
+	registerCollector("image", collectImageMetrics, containerImageInfo, imageContainers, containerArchInfo, containerArchMismatch)
+
+	// Register the runtime metrics, only exposed when enabled
+	runtimeRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
 }
 
 func initLogger() {
@@ -55,30 +117,39 @@ func initLogger() {
 		fmt.Printf("Error creating zap logger: %v", err)
 		os.Exit(1)
 	}
+	// Errors repeat every cycle while the cause persists
+	logger = dedupLogs(logger)
 }
 
-func collectDockerMetrics(cli *client.Client) {
-	ctx := context.Background()
-
+func collectImageMetrics(ctx context.Context, cli *client.Client) {
 	// List all containers
-	containers, err := cli.ContainerList(ctx, typeContainer.ListOptions{})
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
 	if err != nil {
 		logger.Error("Error listing containers", zap.Error(err))
 		return
 	}
 
-	// Clear old metrics to avoid duplicates
-	containerImageInfo.Reset()
+	// Host architecture to compare images with
+	hostArch := ""
+	if info, err := cli.Info(ctx); err != nil {
+		logger.Error("Error getting Docker info", zap.Error(err))
+	} else {
+		hostArch = hostArchitecture(info.Architecture)
+	}
 
-	// Collect metrics for each container
+	// Collect metrics for each container, reusing the label values
+	labels := make([]string, 0, 4)
+	usedBy := map[string]int{}
 	for _, container := range containers {
 		containerName := container.Names[0]
 		imageID := container.ImageID
+		usedBy[imageID]++
 
 		// Fetch full image information
 		image, _, err := cli.ImageInspectWithRaw(ctx, container.Image)
 		if err != nil {
 			logger.Error("Error inspecting image for container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageImageInspect, err)
 			continue
 		}
 
@@ -88,60 +159,146 @@ func collectDockerMetrics(cli *client.Client) {
 			imageRepo = image.RepoTags[0]
 		}
 
-		// Set the metric with container name, image ID, and repo path as labels
-		containerImageInfo.WithLabelValues(containerName, imageID, imageRepo).Set(1)
-	}
-}
+		// Set the metric with container name, image ID, repo path and registry as labels
+		labels = append(labels[:0], containerName, imageID, imageRepo, imageOrigin(image.RepoTags, image.RepoDigests))
+		imageInfoSeries.set(containerImageInfo, 1, labels)
 
-func writeMetricsToFile(metricsFilePath string, metric prometheus.Collector) error {
-	// Create or truncate the file
-
-	registry := prometheus.NewRegistry()
-	if err := registry.Register(metric); err != nil {
-		logger.Error("Error registering metric", zap.Error(err))
-		return fmt.Errorf("error registering metric: %w", err)
+		if hostArch != "" {
+			labels = append(labels[:0], containerName, image.Architecture, hostArch)
+			archInfoSeries.set(containerArchInfo, 1, labels)
+			mismatch := 0.0
+			if image.Architecture != hostArch && !slices.Contains(nativeArchs[hostArch], image.Architecture) {
+				mismatch = 1
+			}
+			archMismatchSeries.set(containerArchMismatch, mismatch, labels[:1])
+		}
 	}
 
-	promFile := filepath.Join(metricsFilePath, "docker_metrics.prom")
-	logger.Debug("Writing metrics to file", zap.String("file", promFile))
-	file, err := os.OpenFile(promFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	// Remove series of containers that went away
+	imageInfoSeries.sweep()
+	archInfoSeries.sweep()
+	archMismatchSeries.sweep()
 
+	// Count running containers per local image, unused images are prune candidates
+	images, err := cli.ImageList(ctx, image.ListOptions{})
 	if err != nil {
-		logger.Error("Error opening metrics file", zap.Error(err))
-		return fmt.Errorf("error opening metrics file: %w", err)
+		logger.Error("Error listing images", zap.Error(err))
+		imageContainerSeries.sweep()
+		return
 	}
-	defer file.Close()
+	for _, img := range images {
+		imageRepo := "unknown"
+		if len(img.RepoTags) > 0 {
+			imageRepo = img.RepoTags[0]
+		}
+		labels = append(labels[:0], img.ID, imageRepo, imageOrigin(img.RepoTags, img.RepoDigests))
+		imageContainerSeries.set(imageContainers, float64(usedBy[img.ID]), labels)
+	}
+	imageContainerSeries.sweep()
+}
 
-	// Gather metrics and encode in Prometheus text format
-	gatherers := prometheus.Gatherers{registry}
-	metrics, err := gatherers.Gather()
-	if err != nil {
-		logger.Error("Error gathering metrics", zap.Error(err))
-		return fmt.Errorf("error gathering metrics: %w", err)
+func imageOrigin(repoTags, repoDigests []string) string {
+	// Registry the image came from, untagged images still have their digest reference
+	if len(repoTags) > 0 {
+		return imageRegistry(repoTags[0])
 	}
+	if len(repoDigests) > 0 {
+		return imageRegistry(repoDigests[0])
+	}
+	return "unknown"
+}
 
-	encoder := expfmt.NewEncoder(file, PromText)
-	for _, metric := range metrics {
-		if err := encoder.Encode(metric); err != nil {
-			logger.Error("Error encoding metrics", zap.Error(err))
-			return fmt.Errorf("error encoding metrics: %w", err)
-		}
+func hostArchitecture(machine string) string {
+	if arch, ok := machineArchs[machine]; ok {
+		return arch
 	}
-	logger.Debug("Metrics written to file")
+	// ppc64le, s390x and riscv64 have the same names
+	return machine
+}
 
-	return nil
+func newGatherer(enabled []*collector, runtimeMetrics bool) prometheus.Gatherer {
+	return collectorsGatherer(enabled, true, runtimeMetrics)
+}
+
+func collectorsGatherer(enabled []*collector, exporter, runtimeMetrics bool) prometheus.Gatherer {
+	// Metrics of the enabled collectors, plus exporter metrics once when
+	// collecting from several daemons and runtime metrics only when requested
+	gatherers := prometheus.Gatherers{collectionRegistry}
+	if exporter {
+		gatherers = append(gatherers, exporterRegistry)
+	}
+	for _, c := range enabled {
+		gatherers = append(gatherers, c.registry)
+	}
+	if runtimeMetrics {
+		gatherers = append(gatherers, runtimeRegistry)
+	}
+	return processGatherer(gatherers)
 }
 
 func main() {
+	// Subcommands have flags of their own
+	if len(os.Args) > 1 && os.Args[1] == "aggregate" {
+		runAggregate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+
 	port := flag.String("port", "8000", "Port to listen on for Prometheus metrics")
 	metricsFilePath := flag.String("metricsFilePath", "", "Path to write Prometheus metrics (disables HTTP listener if set)")
 	interval := flag.Duration("interval", 10*time.Second, "Interval to collect metrics")
 	debug := flag.Bool("debug", false, "Enable debug logging")
+	fileTimestamps := flag.Bool("fileTimestamps", false, "Stamp samples in the metrics file with the collection time (not supported by the node_exporter textfile collector)")
+	fileLayout := flag.String("fileLayout", layoutSingle, "Metrics file layout: single (see fileName) or per-collector (docker_<collector>.prom)")
+	fileName := flag.String("fileName", defaultFileName, "Name of the metrics file for the single file layout")
+	fileMode := flag.String("fileMode", "0644", "Permissions of the metrics files, in octal")
+	fileOwner := flag.String("fileOwner", "", "User name or uid owning the metrics files (requires root)")
+	fileGroup := flag.String("fileGroup", "", "Group name or gid owning the metrics files (requires root)")
+	fileMeta := flag.Bool("fileMeta", false, "Write a <name>.meta.json file next to each metrics file with the collection time, exporter version, series count and SHA-256")
+	fileClashes := flag.String("fileClashes", clashWarn, "Handling of metrics also written by other producers to .prom files in the metrics directory: warn, refuse (leave them out) or ignore")
+	enabledNames := flag.String("collectors", "image", "Comma separated list of collectors to enable")
+	runtimeMetrics := flag.Bool("runtimeMetrics", false, "Include Go runtime and process metrics of the exporter in the output")
+	dryRun := flag.Bool("dryRun", false, "Collect once, print the metrics with series counts and label cardinalities, and exit")
 
-	flag.Parse()
+	// Subcommands sharing the daemon flags and config file
+	subcommand := ""
+	if len(os.Args) > 1 && (os.Args[1] == "outdated" || os.Args[1] == "dashboard" || os.Args[1] == "rules" || os.Args[1] == previewSubcommand) {
+		subcommand = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			fmt.Printf("Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	recordFlagSources()
+	if err := applyLowPower(); err != nil {
+		fmt.Printf("Error applying low power profile: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *metricOverridesFile != "" {
+		if err := loadMetricOverrides(*metricOverridesFile); err != nil {
+			fmt.Printf("Error loading metric overrides: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *derivedMetricsFile != "" {
+		if err := loadDerivedMetrics(*derivedMetricsFile); err != nil {
+			fmt.Printf("Error loading derived metrics: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	if err := os.Setenv("DEBUG", fmt.Sprintf("%t", *debug)); err != nil {
-		fmt.Errorf("error setting DEBUG env variable: %w", err)
+		fmt.Printf("Error setting DEBUG env variable: %v", err)
 		os.Exit(1)
 	}
 
@@ -149,6 +306,48 @@ func main() {
 	initLogger()
 	defer logger.Sync()
 
+	enabled, err := enabledCollectors(*enabledNames)
+	if err != nil {
+		logger.Fatal("Error enabling collectors", zap.Error(err))
+	}
+	enabled = lowPowerCollectors(enabled)
+
+	if subcommand == "dashboard" {
+		// Panels of the enabled collectors, named as this config exports them
+		if err := writeDashboard(os.Stdout, enabled); err != nil {
+			logger.Fatal("Error writing dashboard", zap.Error(err))
+		}
+		return
+	}
+	if subcommand == "rules" {
+		if err := writeRules(os.Stdout, enabled); err != nil {
+			logger.Fatal("Error writing rules", zap.Error(err))
+		}
+		return
+	}
+
+	// Always reported, only enforced when required
+	if err := checkHardening(enabled); err != nil && *requireHardened {
+		logger.Fatal("Refusing to start without hardening", zap.Error(err))
+	}
+
+	if tenantRules, err = parseTenantRules(*tenantRulesFlag); err != nil {
+		logger.Fatal("Error parsing tenant rules", zap.Error(err))
+	}
+	if err := setupOutbound(); err != nil {
+		logger.Fatal("Error configuring outbound connections", zap.Error(err))
+	}
+
+	if err := loadRegistryAuths(); err != nil {
+		logger.Fatal("Error loading registry credentials", zap.Error(err))
+	}
+
+	memoryLimit := setMemoryLimit()
+
+	// Stop collecting on SIGINT/SIGTERM so the output can be cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Create Docker client
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -156,30 +355,254 @@ func main() {
 	}
 	logger.Debug("Docker client created")
 
+	if subcommand == "outdated" {
+		// Exit code is the number of outdated containers, for cron
+		os.Exit(runOutdated(ctx, cli, os.Stdout))
+	}
+
+	if subcommand == previewSubcommand {
+		collectDockerMetrics(ctx, cli, enabled)
+		if err := printSeries(os.Stdout, newGatherer(enabled, *runtimeMetrics)); err != nil {
+			logger.Fatal("Error printing series", zap.Error(err))
+		}
+		return
+	}
+
+	if *dryRun {
+		collectDockerMetrics(ctx, cli, enabled)
+		if err := printDryRun(os.Stdout, newGatherer(enabled, *runtimeMetrics)); err != nil {
+			logger.Fatal("Error printing metrics", zap.Error(err))
+		}
+		return
+	}
+
+	daemons, err := newDaemons(cli, enabled)
+	if err != nil {
+		logger.Fatal("Error configuring Docker endpoints", zap.Error(err))
+	}
+	// Admin APIs and background work are of the first daemon
+	cli = daemons[0].cli
+
+	// Start background work of collectors that track events or sample often
+	startCollectors(ctx, cli, daemons[0].enabled)
+
+	if *heartbeatURL != "" {
+		go runHeartbeat(ctx, cli)
+	}
+
+	// Collect from every daemon, scrapes and file writes are served from
+	// snapshots each daemon takes after its collection
+	var writes, zabbixSends chan time.Time
+	var fileOut *fileOutput
+	cycle := func() {
+		collectedAt := time.Now()
+		// The first daemon's snapshots hold the exporter metrics, it's
+		// collected last for them to cover the other daemons of the cycle
+		for i := len(daemons) - 1; i >= 0; i-- {
+			daemons[i].collect(ctx)
+		}
+
+		if writes != nil {
+			requestWrite(writes, collectedAt)
+		}
+		if zabbixSends != nil {
+			requestZabbixSend(zabbixSends, collectedAt)
+		}
+		checkMemory(enabled, memoryLimit)
+		if !warmupDone() {
+			logger.Info("Warmup collection finished", zap.Duration("duration", time.Since(collectedAt)))
+			close(warmedUp)
+		}
+	}
+	// Scrapes collect unless the last collection is within the max age, the
+	// outputs pushing metrics collect every interval
+	periodic := *metricsFilePath != "" || *zabbixServer != ""
+	var scraped prometheus.Gatherer
+	if *metricsFilePath == "" {
+		scraped = daemonsGatherer(daemons, func(d *daemon, primary bool) prometheus.Gatherer {
+			return collectorsGatherer(d.enabled, primary, *runtimeMetrics && primary)
+		})
+	}
+	collections := newScrapeCollector(scraped, cycle, *scrapeMaxAge)
+
 	// Disable HTTP listener if metricsFile is specified
 	if *metricsFilePath == "" {
 		// Start Prometheus HTTP server
-		http.Handle("/metrics", promhttp.Handler())
+		scrapes := prometheus.NewRegistry()
+		scrapes.MustRegister(collections)
+		if *tenantTokensFile != "" {
+			if err := loadTenantTokens(*tenantTokensFile); err != nil {
+				logger.Fatal("Error loading tenant tokens", zap.Error(err))
+			}
+			if len(tenantRules) == 0 {
+				logger.Warn("Tenant tokens configured without tenant rules, tenant scrapes will be empty")
+			}
+			if *adminToken == "" {
+				logger.Warn("Tenant tokens configured without an admin token, the status and plan endpoints will refuse every request")
+			}
+			http.HandleFunc("/metrics", newTenantScrapeHandler(scrapes))
+		} else {
+			http.Handle("/metrics", promhttp.HandlerFor(scrapes, promhttp.HandlerOpts{}))
+		}
+		if *tenantEndpoints {
+			if len(tenantRules) == 0 {
+				logger.Warn("Tenant endpoints requested without tenant rules, they will be empty")
+			}
+			http.HandleFunc("/tenants/", newTenantMetricsHandler(scrapes))
+		}
+		http.HandleFunc("/api/v1/status/config", adminOnlyWithTenants(newConfigStatusHandler(enabled)))
+		if *prunePlanEnabled {
+			if !collectorEnabled(enabled, "prune") {
+				logger.Warn("Prune plan requested but the prune collector is not enabled")
+			}
+			http.HandleFunc("/api/v1/prune-plan", adminOnlyWithTenants(handlePrunePlan))
+		}
+		if *restartCandidatesEnabled {
+			if !collectorEnabled(enabled, "distribution") {
+				logger.Warn("Restart candidates requested without the distribution collector, registry checks are skipped")
+			}
+			http.HandleFunc("/api/v1/restart-candidates", adminOnlyWithTenants(newRestartCandidatesHandler(cli)))
+		}
+		// Mutations of the host must leave a record that outlives the logs.
+		// The config is only read at startup, there is no reload to audit.
+		if (*pruneAPIEnabled || *restartAPIEnabled || *maintenanceEnabled) && *auditLogPath == "" {
+			logger.Fatal("The prune, restart and maintenance APIs require an audit log")
+		}
+		if *auditLogPath != "" {
+			if err := openAuditLog(); err != nil {
+				logger.Fatal("Error opening audit log", zap.Error(err))
+			}
+		}
+		if *pruneAPIEnabled {
+			if *adminToken == "" {
+				logger.Fatal("The prune API requires an admin token")
+			}
+			http.HandleFunc("/api/v1/prune", requireAdmin("prune", newPruneHandler(cli)))
+		}
+		if *restartAPIEnabled {
+			if *adminToken == "" {
+				logger.Fatal("The restart API requires an admin token")
+			}
+			http.HandleFunc("/api/v1/restart", requireAdmin("restart", newRestartHandler(cli)))
+		}
+		if *maintenanceEnabled {
+			if *adminToken == "" {
+				logger.Fatal("The maintenance API requires an admin token")
+			}
+			http.HandleFunc("/api/v1/maintenance", requireAdmin("maintenance", handleMaintenance))
+		}
+		if *previewAPIEnabled {
+			if *adminToken == "" {
+				logger.Fatal("The preview API requires an admin token")
+			}
+			http.HandleFunc("/-/preview", requireAdmin("preview", newPreviewHandler()))
+		}
+		if *mdnsEnabled {
+			announcer, err := newMDNSAnnouncer(ctx, cli, *port)
+			if err != nil {
+				logger.Fatal("Error setting up mDNS", zap.Error(err))
+			}
+			go announcer.run(ctx)
+		}
+		if *registerStore != "" {
+			if err := startRegistration(ctx, *port); err != nil {
+				logger.Fatal("Error registering exporter", zap.Error(err))
+			}
+		}
+		if *idleTimeout > 0 {
+			// Shuts down like on SIGTERM
+			go watchIdle(ctx, *idleTimeout, stop)
+		}
 		go func() {
-			logger.Info("Starting Prometheus metrics server", zap.String("port", *port))
-			if err := http.ListenAndServe(":"+*port, nil); err != nil {
+			logger.Info("Starting Prometheus metrics server", zap.String("address", *listenAddress), zap.String("network", *listenNetwork), zap.String("port", *port))
+			if err := listenAndServe(*listenNetwork, *listenAddress, *port); err != nil {
 				logger.Fatal("Error starting HTTP server", zap.Error(err))
 			}
 		}()
 	} else {
 		logger.Info("Metrics file path specified", zap.String("path", *metricsFilePath))
+		if *idleTimeout > 0 {
+			logger.Warn("Idle timeout ignored, there are no scrapes when writing a metrics file")
+		}
 	}
 
+	writerDone := make(chan struct{})
+	if *metricsFilePath != "" {
+		fileOut, err = newFileOutput(*metricsFilePath, *fileLayout, *fileName, *fileMode, *fileOwner, *fileGroup, *fileClashes, *fileTimestamps, *fileMeta)
+		if err != nil {
+			logger.Fatal("Error configuring metrics files", zap.Error(err))
+		}
+		// Files of the same name are merged across daemons
+		files := map[string]prometheus.Gatherer{}
+		for i, d := range daemons {
+			primary := i == 0
+			daemonFiles, err := fileOut.files(d.enabled, primary, *runtimeMetrics && primary)
+			if err != nil {
+				logger.Fatal("Error configuring metrics files", zap.Error(err))
+			}
+			for name, gatherer := range daemonFiles {
+				s := d.snapshot(gatherer)
+				if existing, ok := files[name]; ok {
+					files[name] = prometheus.Gatherers{existing, s}
+				} else {
+					files[name] = s
+				}
+			}
+		}
+		fileOut.cleanup(files)
+
+		writes = make(chan time.Time, 1)
+		go func() {
+			defer close(writerDone)
+			fileOut.runWriter(files, writes)
+		}()
+	}
+
+	if *zabbixServer != "" {
+		// Sent alongside the scrapes or files, from snapshots of their own
+		sender, err := newZabbixSender(daemonsGatherer(daemons, func(d *daemon, primary bool) prometheus.Gatherer {
+			return collectorsGatherer(d.enabled, primary, *runtimeMetrics && primary)
+		}))
+		if err != nil {
+			logger.Fatal("Error configuring Zabbix sender", zap.Error(err))
+		}
+		zabbixSends = make(chan time.Time, 1)
+		go sender.run(zabbixSends)
+	}
+
+	// Dump internal state to the log on SIGUSR1, for debugging stuck exporters
+	dumpRequests := make(chan os.Signal, 1)
+	signal.Notify(dumpRequests, syscall.SIGUSR1)
+
+	offset := jitterOffset(min(*alignJitter, *interval))
+
 	// Continuously collect metrics and either write to file or expose over HTTP
 	for {
-		collectDockerMetrics(cli)
-
-		if *metricsFilePath != "" {
-			if err := writeMetricsToFile(*metricsFilePath, containerImageInfo); err != nil {
-				logger.Error("Error writing metrics to file", zap.Error(err))
+		// Without outputs pushing metrics, the loop only waits for shutdown
+		var next <-chan time.Time
+		if periodic {
+			collections.refresh(0)
+			sleep := nextCollection(time.Now(), *interval, *alignInterval, offset)
+			logger.Debug("Metrics collected, sleeping", zap.Duration("interval", *interval), zap.Duration("sleep", sleep))
+			next = time.After(sleep)
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("Shutting down")
+				// Don't leave ghost data behind for node_exporter to serve
+				if fileOut != nil {
+					close(writes)
+					<-writerDone
+					fileOut.cleanup(nil)
+				}
+				return
+			case <-dumpRequests:
+				dumpState(enabled, fileOut)
+			case <-next:
+				break wait
 			}
 		}
-		logger.Debug("Metrics collected, sleeping", zap.Duration("interval", *interval))
-		time.Sleep(*interval)
 	}
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// Service type Prometheus DNS-SD setups look up
+	mdnsService = "_prometheus-http._tcp.local."
+	// Meta query listing all service types on the link
+	mdnsServiceEnum = "_services._dns-sd._udp.local."
+	mdnsTTL         = 120
+	// Class of records only this host answers, tells caches to replace older ones
+	mdnsCacheFlush = dnsmessage.ClassINET | 1<<15
+)
+
+var (
+	mdnsEnabled  = flag.Bool("mdns", false, "Announce the metrics endpoint via mDNS as _prometheus-http._tcp")
+	mdnsInstance = flag.String("mdnsInstance", "", "mDNS instance name of the exporter (default the host name)")
+
+	mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+)
+
+// mdnsAnnouncer answers mDNS queries for the exporter's service
+type mdnsAnnouncer struct {
+	conn     *net.UDPConn
+	instance string
+	host     string
+	port     uint16
+	txt      []string
+}
+
+func newMDNSAnnouncer(ctx context.Context, cli *client.Client, port string) (*mdnsAnnouncer, error) {
+	portNumber, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing port %q: %w", port, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error getting host name: %w", err)
+	}
+	// Dots would split the names into more labels
+	hostLabel := strings.ReplaceAll(hostname, ".", "-")
+	instance := *mdnsInstance
+	if instance == "" {
+		instance = hostLabel
+	}
+
+	// Host metadata for DNS-SD browsers
+	txt := []string{"path=/metrics", "hostname=" + hostname, "os=" + runtime.GOOS, "arch=" + runtime.GOARCH}
+	if version, err := cli.ServerVersion(ctx); err != nil {
+		logger.Warn("Error getting Docker version for mDNS", zap.Error(err))
+	} else {
+		txt = append(txt, "docker_version="+version.Version)
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("error joining mDNS group: %w", err)
+	}
+	return &mdnsAnnouncer{
+		conn:     conn,
+		instance: strings.ReplaceAll(instance, ".", "-") + "." + mdnsService,
+		host:     hostLabel + ".local.",
+		port:     uint16(portNumber),
+		txt:      txt,
+	}, nil
+}
+
+func (a *mdnsAnnouncer) run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		// Tell caches to drop the records before going away
+		if err := a.send(0); err != nil {
+			logger.Error("Error sending mDNS goodbye", zap.Error(err))
+		}
+		a.conn.Close()
+	}()
+
+	// Unsolicited announcements, repeated as packets get lost
+	for i := 0; i < 2; i++ {
+		if err := a.send(mdnsTTL); err != nil {
+			logger.Error("Error sending mDNS announcement", zap.Error(err))
+		}
+		time.Sleep(time.Second)
+	}
+	logger.Info("Announcing via mDNS", zap.String("instance", a.instance), zap.String("host", a.host))
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Error reading mDNS query", zap.Error(err))
+			}
+			return
+		}
+		if a.matches(buf[:n]) {
+			if err := a.send(mdnsTTL); err != nil {
+				logger.Error("Error answering mDNS query", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (a *mdnsAnnouncer) matches(packet []byte) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil || header.Response {
+		return false
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		logger.Debug("Error parsing mDNS query", zap.Error(err))
+		return false
+	}
+	for _, q := range questions {
+		switch strings.ToLower(q.Name.String()) {
+		case mdnsService, mdnsServiceEnum, strings.ToLower(a.instance), strings.ToLower(a.host):
+			return true
+		}
+	}
+	return false
+}
+
+func (a *mdnsAnnouncer) send(ttl uint32) error {
+	packet, err := a.response(ttl)
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.WriteToUDP(packet, mdnsGroup)
+	return err
+}
+
+func (a *mdnsAnnouncer) response(ttl uint32) ([]byte, error) {
+	service := dnsmessage.MustNewName(mdnsService)
+	instance, err := dnsmessage.NewName(a.instance)
+	if err != nil {
+		return nil, fmt.Errorf("error building mDNS instance name: %w", err)
+	}
+	host, err := dnsmessage.NewName(a.host)
+	if err != nil {
+		return nil, fmt.Errorf("error building mDNS host name: %w", err)
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	shared := dnsmessage.ResourceHeader{Class: dnsmessage.ClassINET, TTL: ttl}
+	unique := dnsmessage.ResourceHeader{Class: mdnsCacheFlush, TTL: ttl}
+
+	shared.Name = dnsmessage.MustNewName(mdnsServiceEnum)
+	if err := b.PTRResource(shared, dnsmessage.PTRResource{PTR: service}); err != nil {
+		return nil, err
+	}
+	shared.Name = service
+	if err := b.PTRResource(shared, dnsmessage.PTRResource{PTR: instance}); err != nil {
+		return nil, err
+	}
+	unique.Name = instance
+	if err := b.SRVResource(unique, dnsmessage.SRVResource{Target: host, Port: a.port}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(unique, dnsmessage.TXTResource{TXT: a.txt}); err != nil {
+		return nil, err
+	}
+	unique.Name = host
+	for _, addr := range hostAddresses() {
+		if err := b.AResource(unique, dnsmessage.AResource{A: addr.As4()}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func hostAddresses() []netip.Addr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Error("Error listing interfaces", zap.Error(err))
+		return nil
+	}
+	var ips []netip.Addr
+	for _, iface := range ifaces {
+		// Container bridges are no use to scrapers on the link
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 ||
+			strings.HasPrefix(iface.Name, "docker") || strings.HasPrefix(iface.Name, "br-") || strings.HasPrefix(iface.Name, "veth") {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			prefix, err := netip.ParsePrefix(addr.String())
+			if err != nil {
+				continue
+			}
+			if ip := prefix.Addr(); ip.Is4() && !ip.IsLinkLocalUnicast() {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Share of the memory limit above which caches are dropped
+	memoryShrinkRatio = 0.9
+)
+
+var (
+	memoryLimitMiB = flag.Int("memoryLimitMiB", 0, "Soft memory limit of the exporter in MiB, caches are dropped when nearing it (0 uses GOMEMLIMIT if set)")
+
+	exporterRSS = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_resident_memory_bytes",
+			Help: "Resident memory of the exporter process",
+		},
+	)
+	exporterMemoryLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_memory_limit_bytes",
+			Help: "Soft memory limit of the exporter, 0 when unlimited",
+		},
+	)
+	cacheShrinks = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "docker_prom_cache_shrinks_total",
+			Help: "Number of times caches were dropped because the exporter neared its memory limit",
+		},
+	)
+)
+
+func init() {
+	exporterRegistry.MustRegister(exporterRSS, exporterMemoryLimit, cacheShrinks)
+}
+
+func setMemoryLimit() int64 {
+	if *memoryLimitMiB > 0 {
+		debug.SetMemoryLimit(int64(*memoryLimitMiB) << 20)
+	}
+	// A negative value only reads the limit, which GOMEMLIMIT may have set
+	limit := debug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		limit = 0
+	}
+	exporterMemoryLimit.Set(float64(limit))
+	return limit
+}
+
+func checkMemory(enabled []*collector, limit int64) {
+	rss, err := residentMemory()
+	if err != nil {
+		logger.Debug("Error reading exporter memory usage", zap.Error(err))
+		return
+	}
+	exporterRSS.Set(float64(rss))
+	if limit == 0 || float64(rss) < float64(limit)*memoryShrinkRatio {
+		return
+	}
+
+	logger.Warn("Exporter nearing its memory limit, dropping caches", zap.Int64("rss", rss), zap.Int64("limit", limit))
+	cacheShrinks.Inc()
+	for _, c := range enabled {
+		if c.shrink != nil {
+			c.shrink()
+		}
+	}
+	debug.FreeOSMemory()
+}
+
+func residentMemory() (int64, error) {
+	// Second field of statm is the resident set in pages
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected /proc/self/statm format")
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+var (
+	// Set at build time with -ldflags "-X main.exporterVersion=v1.2.3",
+	// otherwise taken from the build info
+	exporterVersion = ""
+)
+
+// fileMeta is written next to a metrics file, so config management can check
+// the file is fresh and complete
+type fileMeta struct {
+	CollectedAt     time.Time `json:"collectedAt"`
+	ExporterVersion string    `json:"exporterVersion"`
+	Series          int       `json:"series"`
+	SHA256          string    `json:"sha256"`
+}
+
+func newFileMeta(collectedAt time.Time, metrics []*dto.MetricFamily, checksum string) fileMeta {
+	meta := fileMeta{CollectedAt: collectedAt.UTC(), ExporterVersion: version(), SHA256: checksum}
+	for _, mf := range metrics {
+		meta.Series += len(mf.GetMetric())
+	}
+	return meta
+}
+
+func version() string {
+	if exporterVersion != "" {
+		return exporterVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	// Builds from a checkout report (devel), the commit tells more
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && info.Main.Version == "(devel)" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
+
+func metaFileName(name string) string {
+	return strings.TrimSuffix(name, ".prom") + ".meta.json"
+}
+
+func (o *fileOutput) writeMeta(promFile string, meta fileMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding metrics metadata: %w", err)
+	}
+
+	// Replaced like the metrics file, readers never see a partial one
+	metaFile := filepath.Join(filepath.Dir(promFile), metaFileName(filepath.Base(promFile)))
+	file, err := os.CreateTemp(filepath.Dir(metaFile), "."+filepath.Base(metaFile)+".tmp*")
+	if err != nil {
+		logger.Error("Error creating temporary metadata file", zap.Error(err))
+		return fmt.Errorf("error creating temporary metadata file: %w", err)
+	}
+	tmpFile := file.Name()
+	defer func() {
+		if err := os.Remove(tmpFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Error removing temporary metadata file", zap.String("file", tmpFile), zap.Error(err))
+		}
+	}()
+
+	err = file.Chmod(o.mode)
+	if err == nil && (o.uid != -1 || o.gid != -1) {
+		err = file.Chown(o.uid, o.gid)
+	}
+	if err == nil {
+		_, err = file.Write(append(data, '\n'))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Error("Error writing metadata file", zap.Error(err))
+		return fmt.Errorf("error writing metadata file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile, metaFile); err != nil {
+		logger.Error("Error renaming metadata file", zap.Error(err))
+		return fmt.Errorf("error renaming metadata file: %w", err)
+	}
+	return nil
+}
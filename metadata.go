@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	hostMetadataFile   = flag.String("metadataFile", "", "JSON or YAML file of flat key/values describing the host (e.g. site, rack, environment), reread when it changes")
+	hostMetadataLabels = flag.Bool("metadataLabels", false, "Add the host metadata as labels to every series instead of only exporting docker_host_metadata_info")
+
+	// Metadata of the host, by label name
+	hostMetadataMu      sync.RWMutex
+	hostMetadata        map[string]string
+	hostMetadataModTime time.Time
+)
+
+func init() {
+	exporterRegistry.MustRegister(hostMetadataCollector{})
+}
+
+// hostMetadataCollector exports the metadata as an info metric, its labels are
+// only known once the file is read
+type hostMetadataCollector struct{}
+
+func (hostMetadataCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (hostMetadataCollector) Collect(ch chan<- prometheus.Metric) {
+	hostMetadataMu.RLock()
+	defer hostMetadataMu.RUnlock()
+	if len(hostMetadata) == 0 {
+		return
+	}
+	desc := prometheus.NewDesc("docker_host_metadata_info", "Metadata of the host from the metadata file", nil, hostMetadata)
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)
+}
+
+func refreshHostMetadata() {
+	if *hostMetadataFile == "" {
+		return
+	}
+	info, err := os.Stat(*hostMetadataFile)
+	if err != nil {
+		logger.Error("Error reading host metadata", zap.String("file", *hostMetadataFile), zap.Error(err))
+		return
+	}
+	hostMetadataMu.RLock()
+	unchanged := info.ModTime().Equal(hostMetadataModTime)
+	hostMetadataMu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	// Keep the last good metadata while provisioning rewrites the file
+	metadata, err := readHostMetadata(*hostMetadataFile)
+	if err != nil {
+		logger.Error("Error reading host metadata", zap.String("file", *hostMetadataFile), zap.Error(err))
+		return
+	}
+	logger.Info("Host metadata loaded", zap.Any("metadata", metadata))
+
+	hostMetadataMu.Lock()
+	defer hostMetadataMu.Unlock()
+	hostMetadata, hostMetadataModTime = metadata, info.ModTime()
+}
+
+func readHostMetadata(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is valid YAML, one parser reads both
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing host metadata: %w", err)
+	}
+	metadata := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if !validLabelName.MatchString(key) {
+			return nil, fmt.Errorf("invalid host metadata key %q", key)
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("host metadata %s is not a plain value", key)
+		}
+		metadata[key] = fmt.Sprint(value)
+	}
+	return metadata, nil
+}
+
+func addHostMetadataLabels(families []*dto.MetricFamily) {
+	if !*hostMetadataLabels {
+		return
+	}
+	hostMetadataMu.RLock()
+	defer hostMetadataMu.RUnlock()
+	if len(hostMetadata) == 0 {
+		return
+	}
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			// Labels of the series win over the metadata
+			have := make(map[string]bool, len(m.GetLabel()))
+			for _, label := range m.GetLabel() {
+				have[label.GetName()] = true
+			}
+			for name, value := range hostMetadata {
+				if !have[name] {
+					m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+				}
+			}
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// Usage of the host filesystems holding bind mount sources
+	bindMountUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_bind_mount_used_bytes",
+			Help: "Used bytes of the host filesystem holding the bind mount source",
+		},
+		[]string{"container_name", "destination", "source"},
+	)
+	bindMountFree = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_bind_mount_free_bytes",
+			Help: "Bytes available to unprivileged users on the host filesystem holding the bind mount source",
+		},
+		[]string{"container_name", "destination", "source"},
+	)
+
+	// Usage of tmpfs mounts inside containers, which counts against their memory
+	tmpfsUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_tmpfs_used_bytes",
+			Help: "Used bytes of the tmpfs mount in the container",
+		},
+		[]string{"container_name", "mountpoint"},
+	)
+	tmpfsSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_tmpfs_size_bytes",
+			Help: "Size limit of the tmpfs mount in the container",
+		},
+		[]string{"container_name", "mountpoint"},
+	)
+
+	mountGauges = []*prometheus.GaugeVec{bindMountUsed, bindMountFree, tmpfsUsed, tmpfsSize}
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range mountGauges {
+		metrics = append(metrics, g)
+	}
+	c := registerCollector("mounts", collectMountMetrics, metrics...)
+	c.hostFiles = true
+}
+
+func collectMountMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range mountGauges {
+		g.Reset()
+	}
+
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	for _, container := range containers {
+		containerName := container.Names[0]
+		for _, m := range container.Mounts {
+			if m.Type == mount.TypeBind {
+				setBindMountMetrics(containerName, m)
+			}
+		}
+
+		// The pid is only available from the full inspect
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+		if info.State == nil || info.State.Pid == 0 {
+			continue
+		}
+		if err := setTmpfsMetrics(containerName, info.State.Pid); err != nil {
+			logger.Error("Error getting tmpfs usage", zap.String("containerName", containerName), zap.Error(err))
+		}
+	}
+}
+
+func setBindMountMetrics(containerName string, m types.MountPoint) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(hostPath(m.Source), &stat); err != nil {
+		logger.Error("Error getting bind mount filesystem stats", zap.String("containerName", containerName), zap.String("source", m.Source), zap.Error(err))
+		return
+	}
+	bindMountUsed.WithLabelValues(containerName, m.Destination, m.Source).Set(float64((stat.Blocks - stat.Bfree) * uint64(stat.Bsize)))
+	bindMountFree.WithLabelValues(containerName, m.Destination, m.Source).Set(float64(stat.Bavail * uint64(stat.Bsize)))
+}
+
+func setTmpfsMetrics(containerName string, pid int) error {
+	// Mounts of the container's mount namespace, seen through its init process
+	procDir := hostPath("/proc/" + strconv.Itoa(pid))
+	file, err := os.Open(procDir + "/mountinfo")
+	if err != nil {
+		return fmt.Errorf("error opening mountinfo: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The filesystem type follows the " - " separator of the optional fields
+		fields, fsFields, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		mountFields := strings.Fields(fields)
+		fsType := strings.Fields(fsFields)
+		if len(mountFields) < 5 || len(fsType) == 0 || fsType[0] != "tmpfs" {
+			continue
+		}
+		mountpoint := unescapeMountinfo(mountFields[4])
+
+		var stat unix.Statfs_t
+		if err := unix.Statfs(procDir+"/root"+mountpoint, &stat); err != nil {
+			logger.Debug("Error getting tmpfs stats", zap.String("containerName", containerName), zap.String("mountpoint", mountpoint), zap.Error(err))
+			continue
+		}
+		tmpfsUsed.WithLabelValues(containerName, mountpoint).Set(float64((stat.Blocks - stat.Bfree) * uint64(stat.Bsize)))
+		tmpfsSize.WithLabelValues(containerName, mountpoint).Set(float64(stat.Blocks * uint64(stat.Bsize)))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading mountinfo: %w", err)
+	}
+	return nil
+}
+
+func unescapeMountinfo(path string) string {
+	// Spaces, tabs, newlines and backslashes are octal escaped, as \040
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
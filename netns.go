@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	containerConntrackEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_conntrack_entries",
+			Help: "Number of conntrack entries in the network namespace of the container",
+		},
+		[]string{"container_name"},
+	)
+
+	containerTCPSockets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_tcp_sockets",
+			Help: "Number of TCP sockets in the network namespace of the container by state",
+		},
+		[]string{"container_name", "state"},
+	)
+
+	containerPortListening = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_port_listening",
+			Help: "Whether something listens inside the container on the target port of a published TCP port",
+		},
+		[]string{"container_name", "port"},
+	)
+
+	netnsGauges = []*prometheus.GaugeVec{containerConntrackEntries, containerTCPSockets, containerPortListening}
+
+	// Socket states of /proc/net/tcp, from include/net/tcp_states.h
+	tcpStates = map[string]string{
+		"01": "ESTABLISHED",
+		"02": "SYN_SENT",
+		"03": "SYN_RECV",
+		"04": "FIN_WAIT1",
+		"05": "FIN_WAIT2",
+		"06": "TIME_WAIT",
+		"07": "CLOSE",
+		"08": "CLOSE_WAIT",
+		"09": "LAST_ACK",
+		"0A": "LISTEN",
+		"0B": "CLOSING",
+	}
+)
+
+// tcpSocket is a socket line of /proc/net/tcp or tcp6
+type tcpSocket struct {
+	state     string
+	localPort uint16
+}
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range netnsGauges {
+		metrics = append(metrics, g)
+	}
+	c := registerCollector("netns", collectNetnsMetrics, metrics...)
+	c.hostFiles = true
+}
+
+func collectNetnsMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range netnsGauges {
+		g.Reset()
+	}
+
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	for _, container := range containers {
+		containerName := container.Names[0]
+		// Host network containers would report the host namespace
+		if typeContainer.NetworkMode(container.HostConfig.NetworkMode).IsHost() {
+			continue
+		}
+
+		// The pid is only available from the full inspect
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+		if info.State == nil || info.State.Pid == 0 {
+			continue
+		}
+
+		// /proc/<pid>/net shows the network namespace of the process
+		procNet := hostPath("/proc/" + strconv.Itoa(info.State.Pid) + "/net")
+		if err := setConntrackMetrics(containerName, procNet); err != nil {
+			logger.Error("Error counting conntrack entries", zap.String("containerName", containerName), zap.Error(err))
+		}
+
+		sockets, err := readTCPSockets(procNet)
+		if err != nil {
+			logger.Error("Error reading TCP sockets", zap.String("containerName", containerName), zap.Error(err))
+			continue
+		}
+		setSocketMetrics(containerName, sockets)
+		setPortListeningMetrics(containerName, container.Ports, sockets)
+	}
+}
+
+func setPortListeningMetrics(containerName string, ports []types.Port, sockets []tcpSocket) {
+	listening := map[uint16]bool{}
+	for _, socket := range sockets {
+		if socket.state == "LISTEN" {
+			listening[socket.localPort] = true
+		}
+	}
+	// Ports published on IPv4 and IPv6 are listed once each
+	for _, port := range ports {
+		if port.PublicPort == 0 || port.Type != "tcp" {
+			continue
+		}
+		value := 0.0
+		if listening[port.PrivatePort] {
+			value = 1
+		}
+		containerPortListening.WithLabelValues(containerName, strconv.Itoa(int(port.PrivatePort))).Set(value)
+	}
+}
+
+func setConntrackMetrics(containerName, procNet string) error {
+	// Only present with CONFIG_NF_CONNTRACK_PROCFS and once conntrack is in use
+	file, err := os.Open(procNet + "/nf_conntrack")
+	if os.IsNotExist(err) {
+		logger.Debug("No conntrack table for container", zap.String("containerName", containerName))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening conntrack table: %w", err)
+	}
+	defer file.Close()
+
+	entries := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entries++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading conntrack table: %w", err)
+	}
+	containerConntrackEntries.WithLabelValues(containerName).Set(float64(entries))
+	return nil
+}
+
+func readTCPSockets(procNet string) ([]tcpSocket, error) {
+	var sockets []tcpSocket
+	for _, name := range []string{"tcp", "tcp6"} {
+		file, err := os.Open(procNet + "/" + name)
+		if os.IsNotExist(err) {
+			// IPv6 may be disabled
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", name, err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // Header
+		for scanner.Scan() {
+			// sl local_address rem_address st ..., addresses as hex IP:port
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+			_, port, _ := strings.Cut(fields[1], ":")
+			localPort, err := strconv.ParseUint(port, 16, 16)
+			if err != nil {
+				continue
+			}
+			sockets = append(sockets, tcpSocket{state: tcpStates[fields[3]], localPort: uint16(localPort)})
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", name, err)
+		}
+	}
+	return sockets, nil
+}
+
+func setSocketMetrics(containerName string, sockets []tcpSocket) {
+	// All states, so a state going away shows as 0 rather than a gap
+	counts := map[string]int{}
+	for _, state := range tcpStates {
+		counts[state] = 0
+	}
+	for _, socket := range sockets {
+		if socket.state != "" {
+			counts[socket.state]++
+		}
+	}
+	for state, count := range counts {
+		containerTCPSockets.WithLabelValues(containerName, state).Set(float64(count))
+	}
+}
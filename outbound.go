@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var (
+	proxyRules = flag.String("proxyRules", "", "Comma separated proxies per destination as host=proxyURL (user:password@ in the URL for authenticated proxies), matching the host and its subdomains; direct bypasses the proxy. Other destinations follow HTTP_PROXY/HTTPS_PROXY/NO_PROXY. Registry checks are made by the Docker daemon with its own proxy settings")
+	caFile     = flag.String("caFile", "", "PEM file of extra CA certificates trusted for outbound TLS, in addition to the host trust store. Registry checks are made by the Docker daemon with its own trust store, the aggregate subcommand has a caFile of its own")
+
+	// Client for calls leaving the host, e.g. service registration
+	outboundClient = &http.Client{}
+)
+
+// proxyRule routes requests to a host and its subdomains through a proxy
+type proxyRule struct {
+	host string
+	// nil connects directly
+	proxy *url.URL
+}
+
+func parseProxyRules(list string) ([]proxyRule, error) {
+	var rules []proxyRule
+	for _, item := range splitList(list) {
+		host, target, ok := strings.Cut(item, "=")
+		if !ok || host == "" || target == "" {
+			return nil, fmt.Errorf("invalid proxy rule %q", item)
+		}
+		rule := proxyRule{host: strings.ToLower(strings.TrimPrefix(host, "."))}
+		if target != "direct" {
+			proxy, err := url.Parse(target)
+			if err != nil || proxy.Host == "" {
+				// The URL may hold credentials, don't log it
+				return nil, fmt.Errorf("invalid proxy URL for %s", host)
+			}
+			rule.proxy = proxy
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func setupOutbound() error {
+	rules, err := parseProxyRules(*proxyRules)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		// The most specific rule wins
+		host := strings.ToLower(req.URL.Hostname())
+		var match *proxyRule
+		for i, rule := range rules {
+			if (host == rule.host || strings.HasSuffix(host, "."+rule.host)) && (match == nil || len(rule.host) > len(match.host)) {
+				match = &rules[i]
+			}
+		}
+		if match != nil {
+			return match.proxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+	if *caFile != "" {
+		pool, err := caPool(*caFile)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	outboundClient.Transport = transport
+	return nil
+}
+
+func caPool(path string) (*x509.CertPool, error) {
+	// Extends a copy of the host trust store, the store itself is left alone
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("error loading system CA certificates: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
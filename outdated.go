@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+const (
+	// Largest exit code, higher ones are reserved by shells
+	maxExitCode = 125
+)
+
+func runOutdated(ctx context.Context, cli *client.Client, out io.Writer) int {
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Fatal("Error listing containers", zap.Error(err))
+	}
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CONTAINER\tIMAGE\tLOCAL DIGEST\tREGISTRY DIGEST\tSTATUS")
+
+	outdated := 0
+	// Containers of the same image share the registry answer
+	registryDigests := map[string]string{}
+	for _, container := range containers {
+		containerName := container.Names[0]
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			continue
+		}
+		if info.Config == nil {
+			continue
+		}
+		ref := info.Config.Image
+
+		image, _, err := cli.ImageInspectWithRaw(ctx, info.Image)
+		if err != nil {
+			logger.Error("Error inspecting image for container", zap.String("containerName", containerName), zap.Error(err))
+			continue
+		}
+		// Locally built images have no registry counterpart
+		if len(image.RepoDigests) == 0 {
+			fmt.Fprintf(table, "%s\t%s\t-\t-\tlocal\n", containerName, ref)
+			continue
+		}
+
+		registryDigest, ok := registryDigests[ref]
+		if !ok {
+			fetched, err := inspectRegistryImage(ctx, cli, ref)
+			if err != nil {
+				logger.Error("Error inspecting image in registry", zap.String("image", ref), zap.Error(err))
+			}
+			registryDigest = fetched.digest
+			registryDigests[ref] = registryDigest
+		}
+
+		localDigest := repoDigest(image.RepoDigests[0])
+		status := "up to date"
+		switch {
+		case registryDigest == "":
+			status = "unknown"
+		case !hasRepoDigest(image.RepoDigests, registryDigest):
+			status = "outdated"
+			outdated++
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", containerName, ref, shortDigest(localDigest), shortDigest(registryDigest), status)
+	}
+	table.Flush()
+
+	return min(outdated, maxExitCode)
+}
+
+func repoDigest(repoDigest string) string {
+	// repo@sha256:...
+	return repoDigest[strings.LastIndex(repoDigest, "@")+1:]
+}
+
+func hasRepoDigest(repoDigests []string, digest string) bool {
+	for _, d := range repoDigests {
+		if repoDigest(d) == digest {
+			return true
+		}
+	}
+	return false
+}
+
+func shortDigest(digest string) string {
+	if digest == "" {
+		return "-"
+	}
+	// sha256: plus the 12 characters docker shows
+	if n := len("sha256:") + 12; len(digest) > n {
+		return digest[:n]
+	}
+	return digest
+}
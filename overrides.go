@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	metricOverridesFile = flag.String("metricOverrides", "", "YAML file of per metric help and unit overrides, keyed by metric name")
+
+	// Overrides by original metric name, loaded at startup
+	metricOverrides map[string]metricOverride
+)
+
+// metricOverride changes how a metric is exposed
+type metricOverride struct {
+	// Replaces the help string
+	Help string `yaml:"help"`
+	// Appended to the name when missing, before _total for counters
+	Unit string `yaml:"unit"`
+}
+
+func loadMetricOverrides(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading metric overrides: %w", err)
+	}
+	overrides := map[string]metricOverride{}
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("error parsing metric overrides %s: %w", path, err)
+	}
+	for name, override := range overrides {
+		if override.Unit != "" && !validLabelName.MatchString(override.Unit) {
+			return fmt.Errorf("invalid unit %q of %s", override.Unit, name)
+		}
+	}
+	metricOverrides = overrides
+	return nil
+}
+
+func processGatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		families = addDerivedMetrics(families)
+		for _, family := range families {
+			applyMetricOverride(family)
+		}
+		addTenantLabels(families)
+		addAlertLabels(families)
+		addHostMetadataLabels(families)
+		return families, err
+	})
+}
+
+func applyMetricOverride(family *dto.MetricFamily) {
+	override, ok := metricOverrides[family.GetName()]
+	if !ok {
+		return
+	}
+	if override.Help != "" {
+		help := override.Help
+		family.Help = &help
+	}
+	if override.Unit != "" {
+		name := withUnit(family.GetName(), override.Unit, family.GetType() == dto.MetricType_COUNTER)
+		family.Name = &name
+	}
+}
+
+func withUnit(name, unit string, counter bool) string {
+	// Counters end in _total, the unit goes before it
+	base := name
+	if counter {
+		base = strings.TrimSuffix(name, "_total")
+	}
+	if strings.HasSuffix(base, "_"+unit) {
+		return name
+	}
+	base += "_" + unit
+	if counter {
+		base += "_total"
+	}
+	return base
+}
+
+func exportedName(name string, counter bool) string {
+	// Name of the metric once its unit override is applied, for generated
+	// queries to match the output
+	override, ok := metricOverrides[name]
+	if !ok || override.Unit == "" {
+		return name
+	}
+	return withUnit(name, override.Unit, counter)
+}
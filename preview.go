@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const (
+	// Largest candidate config accepted by the preview endpoint
+	maxPreviewConfigSize = 1 << 20
+	// Time allowed for each preview collection
+	previewTimeout = 2 * time.Minute
+	// Subcommand collecting once and printing the series, run by previews
+	previewSubcommand = "preview-series"
+)
+
+var (
+	previewAPIEnabled = flag.Bool("previewAPI", false, "Serve /-/preview, diffing the series of a POSTed candidate config against the active config (requires adminToken)")
+
+	// Settings a candidate config may change, those choosing what is
+	// collected and how it's labelled
+	previewFlags = map[string]bool{
+		"collectors":          true,
+		"enableStats":         true,
+		"statsTopN":           true,
+		"maxContainers":       true,
+		"aggregateNetwork":    true,
+		"utilization":         true,
+		"usageHistograms":     true,
+		"imageLabels":         true,
+		"requiredImageLabels": true,
+		"envLabels":           true,
+		"baseImages":          true,
+		"approvedBaseImages":  true,
+		"commandMaxLength":    true,
+		"tenantRules":         true,
+		"defaultTenant":       true,
+		"alertTeamLabel":      true,
+		"silenceLabel":        true,
+		"dindLabel":           true,
+		"metadataLabels":      true,
+		"swarmLeaderOnly":     true,
+		"runtimeMetrics":      true,
+		"lowPower":            true,
+	}
+	// Settings of the active config the preview collections run with as well,
+	// they describe the host or name files on it, which requests can't choose
+	previewContextFlags = []string{"hostRoot", "dockerRoot", "metadataFile", "collectorTimeout", "statsConcurrency", "registryConfig", "metricOverrides", "derivedMetrics"}
+)
+
+// previewResult is the series diff of a candidate config against the active one
+type previewResult struct {
+	ActiveSeries    int      `json:"activeSeries"`
+	CandidateSeries int      `json:"candidateSeries"`
+	Added           []string `json:"added"`
+	Removed         []string `json:"removed"`
+}
+
+func newPreviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxPreviewConfigSize))
+		if err != nil {
+			http.Error(w, "error reading config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		values, err := parseConfig(data, false)
+		if err != nil {
+			http.Error(w, "error parsing config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for name := range values {
+			if !previewFlags[name] {
+				http.Error(w, fmt.Sprintf("setting %q can't be previewed", name), http.StatusBadRequest)
+				return
+			}
+		}
+
+		logger.Info("Config preview requested", zap.Int("settings", len(values)), zap.String("remote", r.RemoteAddr))
+		result, err := previewConfig(r.Context(), values)
+		if err != nil {
+			logger.Error("Error previewing config", zap.Error(err))
+			http.Error(w, "error previewing config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func previewConfig(ctx context.Context, values map[string]string) (*previewResult, error) {
+	// Both configs are collected by separate exporter processes, which have
+	// flags, caches and registries of their own and leave this one's alone
+	activeSeries, err := previewSeries(ctx, previewArgs(nil))
+	if err != nil {
+		return nil, fmt.Errorf("error collecting with the active config: %w", err)
+	}
+	candidateSeries, err := previewSeries(ctx, previewArgs(values))
+	if err != nil {
+		return nil, fmt.Errorf("error collecting with the candidate config: %w", err)
+	}
+
+	result := &previewResult{
+		ActiveSeries:    len(activeSeries),
+		CandidateSeries: len(candidateSeries),
+		Added:           []string{},
+		Removed:         []string{},
+	}
+	for series := range candidateSeries {
+		if !activeSeries[series] {
+			result.Added = append(result.Added, series)
+		}
+	}
+	for series := range activeSeries {
+		if !candidateSeries[series] {
+			result.Removed = append(result.Removed, series)
+		}
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	return result, nil
+}
+
+func previewArgs(overrides map[string]string) []string {
+	args := []string{previewSubcommand}
+	add := func(name string) {
+		value, ok := overrides[name]
+		if !ok {
+			value = flag.Lookup(name).Value.String()
+		}
+		args = append(args, "-"+name+"="+value)
+	}
+	for name := range previewFlags {
+		add(name)
+	}
+	for _, name := range previewContextFlags {
+		add(name)
+	}
+	return args
+}
+
+func previewSeries(ctx context.Context, args []string) (map[string]bool, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("error finding the exporter executable: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+
+	series := map[string]bool{}
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(nil, maxPreviewConfigSize)
+	for scanner.Scan() {
+		series[scanner.Text()] = true
+	}
+	return series, scanner.Err()
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// printSeries prints the series of the gatherer one per line, for the
+// preview subcommand
+func printSeries(w io.Writer, gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+	out := bufio.NewWriter(w)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			fmt.Fprintln(out, seriesName(family.GetName(), metric))
+		}
+	}
+	return out.Flush()
+}
+
+func seriesName(name string, metric *dto.Metric) string {
+	var labels []string
+	for _, label := range metric.Label {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	if len(labels) == 0 {
+		return name
+	}
+	sort.Strings(labels)
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
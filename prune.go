@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Types of prunable objects, matching the docker prune commands
+	pruneContainers    = "containers"
+	pruneImages        = "images"          // dangling images, freed by docker system prune
+	pruneImagesAll     = "images_all"      // unused images, freed by docker system prune -a
+	pruneVolumes       = "volumes"         // anonymous volumes, freed by docker volume prune
+	pruneVolumesAll    = "volumes_all"     // unused volumes, freed by docker volume prune -a
+	pruneBuildCache    = "build_cache"     // dangling build cache, freed by docker builder prune
+	pruneBuildCacheAll = "build_cache_all" // unused build cache, freed by docker builder prune -a
+
+	// Label the daemon sets on anonymous volumes since API 1.42
+	anonymousVolumeLabel = "com.docker.volume.anonymous"
+)
+
+var (
+	prunePlanEnabled = flag.Bool("prunePlan", false, "Expose the prune candidates as JSON on /api/v1/prune-plan")
+	pruneAPIEnabled  = flag.Bool("pruneAPI", false, "Enable POST /api/v1/prune to prune Docker objects (requires adminToken)")
+
+	pruneReclaimable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_prune_reclaimable_bytes",
+			Help: "Disk space that pruning objects of the type would free",
+		},
+		[]string{"type"},
+	)
+
+	pruneReclaimed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prune_reclaimed_bytes_total",
+			Help: "Disk space freed by prunes triggered through the admin API",
+		},
+		[]string{"type"},
+	)
+
+	// Latest plan, served by the prune plan endpoint
+	prunePlanMu     sync.RWMutex
+	latestPrunePlan *prunePlan
+)
+
+// prunePlan describes what pruning would remove and how much space it frees
+type prunePlan struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Reclaimable map[string]int64 `json:"reclaimable"`
+	Candidates  []pruneCandidate `json:"candidates"`
+}
+
+type pruneCandidate struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// pruneResult is the response of the prune endpoint
+type pruneResult struct {
+	Type      string           `json:"type"`
+	DryRun    bool             `json:"dryRun"`
+	Reclaimed int64            `json:"reclaimed"`
+	Deleted   []string         `json:"deleted,omitempty"`
+	Plan      []pruneCandidate `json:"candidates,omitempty"`
+}
+
+func init() {
+	// The prune plan and API are of the first daemon
+	c := registerCollector("prune", collectPruneMetrics, pruneReclaimable)
+	c.primaryOnly = true
+	exporterRegistry.MustRegister(pruneReclaimed)
+}
+
+func collectPruneMetrics(ctx context.Context, cli *client.Client) {
+	usage, err := cli.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		logger.Error("Error getting disk usage", zap.Error(err))
+		return
+	}
+
+	plan := newPrunePlan(usage)
+	for pruneType, size := range plan.Reclaimable {
+		pruneReclaimable.WithLabelValues(pruneType).Set(float64(size))
+	}
+
+	prunePlanMu.Lock()
+	latestPrunePlan = plan
+	prunePlanMu.Unlock()
+}
+
+func newPrunePlan(usage types.DiskUsage) *prunePlan {
+	plan := &prunePlan{
+		GeneratedAt: time.Now(),
+		Reclaimable: map[string]int64{
+			pruneContainers:    0,
+			pruneImages:        0,
+			pruneImagesAll:     0,
+			pruneVolumes:       0,
+			pruneVolumesAll:    0,
+			pruneBuildCache:    0,
+			pruneBuildCacheAll: 0,
+		},
+	}
+	add := func(pruneType, id, name string, size int64) {
+		plan.Reclaimable[pruneType] += size
+		plan.Candidates = append(plan.Candidates, pruneCandidate{Type: pruneType, ID: id, Name: name, Size: size})
+	}
+
+	for _, c := range usage.Containers {
+		if c.State != "running" && c.State != "paused" && c.State != "restarting" {
+			name := ""
+			if len(c.Names) > 0 {
+				name = c.Names[0]
+			}
+			add(pruneContainers, c.ID, name, c.SizeRw)
+		}
+	}
+
+	for _, img := range usage.Images {
+		if img.Containers > 0 {
+			continue
+		}
+		// Layers shared with other images stay on disk
+		size := img.Size
+		if img.SharedSize > 0 {
+			size -= img.SharedSize
+		}
+		dangling := len(img.RepoTags) == 0 || (len(img.RepoTags) == 1 && img.RepoTags[0] == "<none>:<none>")
+		if dangling {
+			add(pruneImages, img.ID, "", size)
+			// Dangling images are part of an all-images prune as well
+			plan.Reclaimable[pruneImagesAll] += size
+		} else {
+			add(pruneImagesAll, img.ID, img.RepoTags[0], size)
+		}
+	}
+
+	for _, v := range usage.Volumes {
+		if v.UsageData == nil || v.UsageData.RefCount != 0 || v.UsageData.Size <= 0 {
+			continue
+		}
+		// Named volumes hold data, only an all-volumes prune removes them
+		if _, anonymous := v.Labels[anonymousVolumeLabel]; anonymous {
+			add(pruneVolumes, v.Name, v.Name, v.UsageData.Size)
+			plan.Reclaimable[pruneVolumesAll] += v.UsageData.Size
+		} else {
+			add(pruneVolumesAll, v.Name, v.Name, v.UsageData.Size)
+		}
+	}
+
+	for _, cache := range usage.BuildCache {
+		if cache.InUse || cache.Shared {
+			continue
+		}
+		// BuildKit keeps internal and frontend records unless pruning
+		// everything, all other unused records go with a default prune
+		if cache.Type == "internal" || cache.Type == "frontend" {
+			add(pruneBuildCacheAll, cache.ID, cache.Description, cache.Size)
+		} else {
+			add(pruneBuildCache, cache.ID, cache.Description, cache.Size)
+			plan.Reclaimable[pruneBuildCacheAll] += cache.Size
+		}
+	}
+
+	return plan
+}
+
+func handlePrunePlan(w http.ResponseWriter, r *http.Request) {
+	prunePlanMu.RLock()
+	plan := latestPrunePlan
+	prunePlanMu.RUnlock()
+
+	if plan == nil {
+		http.Error(w, "prune plan not collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+func newPruneHandler(cli *client.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pruneType := query.Get("type")
+		dryRun := query.Get("dry-run") == "true"
+		all := query.Get("all") == "true"
+
+		// Unused objects include dangling ones, use the matching plan entries
+		planType := pruneType
+		if all {
+			switch pruneType {
+			case pruneImages:
+				planType = pruneImagesAll
+			case pruneVolumes:
+				planType = pruneVolumesAll
+			case pruneBuildCache:
+				planType = pruneBuildCacheAll
+			}
+		}
+
+		switch pruneType {
+		case pruneContainers, pruneImages, pruneVolumes, pruneBuildCache:
+		default:
+			http.Error(w, "type must be one of containers, images, volumes, build_cache", http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("Prune requested", zap.String("type", pruneType), zap.Bool("dryRun", dryRun), zap.Bool("all", all), zap.String("remote", r.RemoteAddr))
+
+		if dryRun {
+			usage, err := cli.DiskUsage(r.Context(), types.DiskUsageOptions{})
+			if err != nil {
+				logger.Error("Error getting disk usage", zap.Error(err))
+				http.Error(w, "error getting disk usage: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			plan := newPrunePlan(usage)
+			result := pruneResult{Type: pruneType, DryRun: true, Reclaimed: plan.Reclaimable[planType]}
+			for _, candidate := range plan.Candidates {
+				if candidate.Type == planType || (planType != pruneType && candidate.Type == pruneType) {
+					result.Plan = append(result.Plan, candidate)
+				}
+			}
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+
+		result, err := prune(r.Context(), cli, pruneType, all)
+		if err != nil {
+			logger.Error("Error pruning", zap.String("type", pruneType), zap.Error(err))
+			http.Error(w, "error pruning: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		pruneReclaimed.WithLabelValues(pruneType).Add(float64(result.Reclaimed))
+		logger.Info("Prune completed", zap.String("type", pruneType), zap.Int64("reclaimed", result.Reclaimed), zap.Int("deleted", len(result.Deleted)))
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func prune(ctx context.Context, cli *client.Client, pruneType string, all bool) (pruneResult, error) {
+	result := pruneResult{Type: pruneType}
+	switch pruneType {
+	case pruneContainers:
+		report, err := cli.ContainersPrune(ctx, filters.Args{})
+		if err != nil {
+			return result, err
+		}
+		result.Reclaimed = int64(report.SpaceReclaimed)
+		result.Deleted = report.ContainersDeleted
+	case pruneImages:
+		report, err := cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", strconv.FormatBool(!all))))
+		if err != nil {
+			return result, err
+		}
+		result.Reclaimed = int64(report.SpaceReclaimed)
+		for _, deleted := range report.ImagesDeleted {
+			if deleted.Deleted != "" {
+				result.Deleted = append(result.Deleted, deleted.Deleted)
+			}
+		}
+	case pruneVolumes:
+		// Named volumes are only pruned with all=true since API 1.42, older
+		// daemons reject the filter and prune every unused volume
+		args := filters.NewArgs()
+		if all {
+			args.Add("all", "true")
+		}
+		report, err := cli.VolumesPrune(ctx, args)
+		if err != nil {
+			return result, err
+		}
+		result.Reclaimed = int64(report.SpaceReclaimed)
+		result.Deleted = report.VolumesDeleted
+	case pruneBuildCache:
+		report, err := cli.BuildCachePrune(ctx, types.BuildCachePruneOptions{All: all})
+		if err != nil {
+			return result, err
+		}
+		result.Reclaimed = int64(report.SpaceReclaimed)
+		result.Deleted = report.CachesDeleted
+	}
+	return result, nil
+}
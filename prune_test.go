@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+)
+
+func TestNewPrunePlan(t *testing.T) {
+	tests := []struct {
+		name        string
+		usage       types.DiskUsage
+		candidates  map[string]string // ID -> type
+		reclaimable map[string]int64
+	}{
+		{
+			name: "containers",
+			usage: types.DiskUsage{Containers: []*types.Container{
+				{ID: "exited", Names: []string{"/exited"}, State: "exited", SizeRw: 10},
+				{ID: "created", State: "created", SizeRw: 20},
+				{ID: "running", State: "running", SizeRw: 100},
+				{ID: "paused", State: "paused", SizeRw: 100},
+				{ID: "restarting", State: "restarting", SizeRw: 100},
+			}},
+			candidates:  map[string]string{"exited": pruneContainers, "created": pruneContainers},
+			reclaimable: map[string]int64{pruneContainers: 30},
+		},
+		{
+			name: "images",
+			usage: types.DiskUsage{Images: []*image.Summary{
+				{ID: "dangling", Size: 10},
+				{ID: "none", RepoTags: []string{"<none>:<none>"}, Size: 20},
+				{ID: "tagged", RepoTags: []string{"app:1"}, Size: 100, SharedSize: 40},
+				{ID: "used", RepoTags: []string{"app:2"}, Size: 1000, Containers: 1},
+			}},
+			candidates:  map[string]string{"dangling": pruneImages, "none": pruneImages, "tagged": pruneImagesAll},
+			reclaimable: map[string]int64{pruneImages: 30, pruneImagesAll: 90},
+		},
+		{
+			name: "volumes",
+			usage: types.DiskUsage{Volumes: []*volume.Volume{
+				{Name: "anonymous", Labels: map[string]string{anonymousVolumeLabel: ""}, UsageData: &volume.UsageData{Size: 10}},
+				{Name: "named", UsageData: &volume.UsageData{Size: 100}},
+				{Name: "used", UsageData: &volume.UsageData{Size: 1000, RefCount: 1}},
+				{Name: "empty", UsageData: &volume.UsageData{}},
+				{Name: "unknown"},
+			}},
+			candidates:  map[string]string{"anonymous": pruneVolumes, "named": pruneVolumesAll},
+			reclaimable: map[string]int64{pruneVolumes: 10, pruneVolumesAll: 110},
+		},
+		{
+			name: "build cache",
+			usage: types.DiskUsage{BuildCache: []*types.BuildCache{
+				{ID: "regular", Type: "regular", Size: 10},
+				{ID: "source", Type: "source.local", Size: 20},
+				{ID: "internal", Type: "internal", Size: 100},
+				{ID: "frontend", Type: "frontend", Size: 200},
+				{ID: "in-use", Type: "regular", Size: 1000, InUse: true},
+				{ID: "shared", Type: "regular", Size: 1000, Shared: true},
+			}},
+			candidates: map[string]string{
+				"regular":  pruneBuildCache,
+				"source":   pruneBuildCache,
+				"internal": pruneBuildCacheAll,
+				"frontend": pruneBuildCacheAll,
+			},
+			reclaimable: map[string]int64{pruneBuildCache: 30, pruneBuildCacheAll: 330},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := newPrunePlan(tt.usage)
+
+			candidates := map[string]string{}
+			for _, c := range plan.Candidates {
+				candidates[c.ID] = c.Type
+			}
+			if len(candidates) != len(tt.candidates) {
+				t.Errorf("got candidates %v, want %v", candidates, tt.candidates)
+			}
+			for id, want := range tt.candidates {
+				if got := candidates[id]; got != want {
+					t.Errorf("candidate %s has type %q, want %q", id, got, want)
+				}
+			}
+
+			// Types not listed reclaim nothing
+			for pruneType, got := range plan.Reclaimable {
+				if want := tt.reclaimable[pruneType]; got != want {
+					t.Errorf("reclaimable %s = %d, want %d", pruneType, got, want)
+				}
+			}
+		})
+	}
+}
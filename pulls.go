@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// How often in-flight downloads are sampled
+	pullPollInterval = time.Second
+	// Pulls without a pull event after this long failed, they're forgotten
+	pullExpiry = time.Hour
+)
+
+var (
+	dockerRoot = flag.String("dockerRoot", "/var/lib/docker", "Docker root directory as seen by the exporter, used to track in-flight image pulls")
+
+	imagePullsInProgress = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_image_pulls_in_progress",
+			Help: "Number of image pulls currently downloading layers, not tracked with the containerd image store",
+		},
+	)
+	imagePullDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "docker_image_pull_duration_seconds",
+			Help:    "Duration of image pulls that downloaded layers, from their first layer download to completion",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200},
+		},
+		[]string{"registry"},
+	)
+	imagePulls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_image_pulls_total",
+			Help: "Number of completed image pulls",
+		},
+		[]string{"registry"},
+	)
+	imageLayerDownloadsInProgress = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_image_layer_downloads_in_progress",
+			Help: "Number of image layer downloads currently in progress, not tracked with the containerd image store",
+		},
+	)
+	imageLayerDownloadDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "docker_image_layer_download_duration_seconds",
+			Help:    "Duration of image layer downloads, from the first time they're seen to completion",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200},
+		},
+	)
+
+	// Pull tracking state, shared by the download poller and the event handler
+	pullsMu       sync.Mutex
+	pullDownloads = map[string]time.Time{} // in-flight download file -> first seen
+	pullStarts    = map[string]time.Time{} // repository -> first download of its pull
+)
+
+// layerMetadata is an entry of the daemon's v2metadata-by-diffid files, the
+// repositories a layer was pulled from, the latest last
+type layerMetadata struct {
+	SourceRepository string
+}
+
+func init() {
+	c := registerCollector("pulls", nil, imagePullsInProgress, imagePullDuration, imagePulls, imageLayerDownloadsInProgress, imageLayerDownloadDuration)
+	c.start = trackPulls
+	c.hostFiles = true
+	c.handleEvent = handlePullEvent
+	c.dumpState = func() map[string]any {
+		pullsMu.Lock()
+		defer pullsMu.Unlock()
+		return map[string]any{"downloads": len(pullDownloads), "pulls": len(pullStarts)}
+	}
+}
+
+func trackPulls(ctx context.Context, cli *client.Client) {
+	// The daemon only reports finished pulls, in-flight ones are visible as
+	// the GetImageBlob* temp files it downloads layers into. Those don't say
+	// which image they're for, the repository is recorded in the layer's
+	// distribution metadata once the download completes.
+	if info, err := cli.Info(ctx); err != nil {
+		logger.Warn("Error getting Docker info, assuming the graph driver image store", zap.Error(err))
+	} else if containerdImageStore(info.DriverStatus) {
+		logger.Warn("Docker uses the containerd image store, pulls in progress won't be tracked")
+		return
+	}
+	tmpDir := filepath.Join(*dockerRoot, "tmp")
+	if _, err := os.Stat(tmpDir); err != nil {
+		logger.Warn("Docker temp directory not accessible, pulls in progress won't be tracked", zap.String("path", tmpDir), zap.Error(err))
+		return
+	}
+
+	pollInterval := pullPollInterval
+	if *lowPower {
+		pollInterval = lowPowerPullPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		now := time.Now()
+		files, err := filepath.Glob(filepath.Join(tmpDir, "GetImageBlob*"))
+		if err != nil {
+			logger.Error("Error listing in-flight image downloads", zap.Error(err))
+		}
+		if completed, ok := updatePullDownloads(files, now); ok {
+			// Metadata is written as the download completes, allow for the
+			// time the previous poll took
+			startPulls(completedLayerRepositories(last.Add(-pollInterval)), completed)
+		}
+		expirePulls(now)
+		last = now
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func containerdImageStore(driverStatus [][2]string) bool {
+	// Images are pulled by containerd into its content store then, the
+	// daemon's temp directory stays empty
+	for _, status := range driverStatus {
+		if status[0] == "driver-type" && strings.HasPrefix(status[1], "io.containerd.snapshotter") {
+			return true
+		}
+	}
+	return false
+}
+
+// updatePullDownloads tracks the in-flight downloads and returns when the
+// earliest of those completed since the last poll started, if any did
+func updatePullDownloads(files []string, now time.Time) (time.Time, bool) {
+	pullsMu.Lock()
+	defer pullsMu.Unlock()
+
+	// Keep the first seen time of downloads that are still running
+	active := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if seen, ok := pullDownloads[file]; ok {
+			active[file] = seen
+		} else {
+			active[file] = now
+		}
+	}
+	// Those gone since the last poll have completed
+	var completed time.Time
+	for file, seen := range pullDownloads {
+		if _, ok := active[file]; ok {
+			continue
+		}
+		imageLayerDownloadDuration.Observe(now.Sub(seen).Seconds())
+		if completed.IsZero() || seen.Before(completed) {
+			completed = seen
+		}
+	}
+	pullDownloads = active
+	imageLayerDownloadsInProgress.Set(float64(len(active)))
+	setPullsInProgress()
+	return completed, !completed.IsZero()
+}
+
+func completedLayerRepositories(since time.Time) []string {
+	// One file per layer, rewritten with the repository appended each time
+	// the layer is pulled
+	files, err := filepath.Glob(filepath.Join(*dockerRoot, "image", "*", "distribution", "v2metadata-by-diffid", "*", "*"))
+	if err != nil {
+		logger.Error("Error listing layer metadata", zap.Error(err))
+		return nil
+	}
+	seen := map[string]bool{}
+	var repositories []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Debug("Error reading layer metadata", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		var entries []layerMetadata
+		if err := json.Unmarshal(data, &entries); err != nil || len(entries) == 0 {
+			continue
+		}
+		repository := entries[len(entries)-1].SourceRepository
+		if repository != "" && !seen[repository] {
+			seen[repository] = true
+			repositories = append(repositories, repository)
+		}
+	}
+	return repositories
+}
+
+func startPulls(repositories []string, started time.Time) {
+	pullsMu.Lock()
+	defer pullsMu.Unlock()
+
+	// Downloads completing together are credited to each pull they may
+	// belong to, a pull starts at its earliest one
+	for _, repository := range repositories {
+		if start, ok := pullStarts[repository]; !ok || started.Before(start) {
+			pullStarts[repository] = started
+		}
+	}
+	setPullsInProgress()
+}
+
+func expirePulls(now time.Time) {
+	pullsMu.Lock()
+	defer pullsMu.Unlock()
+
+	for repository, start := range pullStarts {
+		if now.Sub(start) > pullExpiry {
+			delete(pullStarts, repository)
+		}
+	}
+	setPullsInProgress()
+}
+
+func setPullsInProgress() {
+	// Pulls are only known by repository once a layer completed, until then
+	// downloads in flight are one pull
+	pulls := len(pullStarts)
+	if pulls == 0 && len(pullDownloads) > 0 {
+		pulls = 1
+	}
+	imagePullsInProgress.Set(float64(pulls))
+}
+
+func handlePullEvent(msg events.Message) {
+	if msg.Type != events.ImageEventType || msg.Action != events.ActionPull {
+		return
+	}
+
+	registry := imageRegistry(msg.Actor.ID)
+	imagePulls.WithLabelValues(registry).Inc()
+
+	named, err := reference.ParseNormalizedNamed(msg.Actor.ID)
+	if err != nil {
+		return
+	}
+	repository := named.Name()
+
+	pullsMu.Lock()
+	defer pullsMu.Unlock()
+
+	// Nothing was downloaded, the image was already up to date
+	start, ok := pullStarts[repository]
+	if !ok {
+		return
+	}
+	delete(pullStarts, repository)
+	finishedAt := time.Unix(0, msg.TimeNano)
+	imagePullDuration.WithLabelValues(registry).Observe(finishedAt.Sub(start).Seconds())
+	setPullsInProgress()
+}
+
+func imageRegistry(ref string) string {
+	// Registry host of an image reference, docker.io when not specified
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "unknown"
+	}
+	return reference.Domain(named)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"go.uber.org/zap"
+)
+
+const (
+	registerEtcd      = "etcd"
+	registerZooKeeper = "zookeeper"
+	minRegisterRetry  = 1 * time.Second
+	maxRegisterRetry  = 1 * time.Minute
+)
+
+var (
+	registerStore     = flag.String("register", "", "Register the exporter address in a service store: etcd or zookeeper")
+	registerEndpoints = flag.String("registerEndpoints", "", "Comma separated list of etcd URLs (http://host:2379) or ZooKeeper servers (host:2181)")
+	registerPrefix    = flag.String("registerPrefix", "/docker-prom", "Key prefix (etcd) or parent node (ZooKeeper) to register under")
+	registerTTL       = flag.Duration("registerTTL", 30*time.Second, "TTL of the registration, refreshed while the exporter runs")
+	registerAddress   = flag.String("registerAddress", "", "Address to register as host:port (default the host name and listen port)")
+)
+
+// serversetMember is a ZooKeeper serverset entry, as read by Prometheus serverset discovery
+type serversetMember struct {
+	ServiceEndpoint     serversetEndpoint            `json:"serviceEndpoint"`
+	AdditionalEndpoints map[string]serversetEndpoint `json:"additionalEndpoints"`
+	Status              string                       `json:"status"`
+}
+
+// serversetEndpoint is an address of a serverset member
+type serversetEndpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// etcdTarget is the value registered in etcd, in the file discovery target format
+type etcdTarget struct {
+	Targets []string `json:"targets"`
+}
+
+// zkLogger sends ZooKeeper client logs to the debug log
+type zkLogger struct{}
+
+func (zkLogger) Printf(format string, args ...interface{}) {
+	logger.Debug(fmt.Sprintf(format, args...))
+}
+
+func startRegistration(ctx context.Context, port string) error {
+	address := *registerAddress
+	if address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("error getting host name: %w", err)
+		}
+		address = net.JoinHostPort(hostname, port)
+	}
+	endpoints := splitList(*registerEndpoints)
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no endpoints to register with")
+	}
+
+	switch *registerStore {
+	case registerEtcd:
+		go registerEtcdLoop(ctx, endpoints, address)
+	case registerZooKeeper:
+		return registerZooKeeperNode(ctx, endpoints, address)
+	default:
+		return fmt.Errorf("unknown register store %q, must be %s or %s", *registerStore, registerEtcd, registerZooKeeper)
+	}
+	return nil
+}
+
+func registerEtcdLoop(ctx context.Context, endpoints []string, address string) {
+	key := path.Join(*registerPrefix, address)
+	value, err := json.Marshal(etcdTarget{Targets: []string{address}})
+	if err != nil {
+		logger.Error("Error encoding etcd registration", zap.Error(err))
+		return
+	}
+
+	backoff := minRegisterRetry
+	for i := 0; ; i++ {
+		// Rotate through the endpoints when one fails
+		etcd := strings.TrimSuffix(endpoints[i%len(endpoints)], "/")
+		err := keepEtcdRegistration(ctx, etcd, key, value)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error("Error registering in etcd", zap.String("endpoint", etcd), zap.Error(err), zap.Duration("retryIn", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRegisterRetry)
+	}
+}
+
+func keepEtcdRegistration(ctx context.Context, etcd, key string, value []byte) error {
+	// The JSON gateway of etcd v3, which takes int64s as strings and bytes as base64
+	var lease struct {
+		ID  string `json:"ID"`
+		TTL string `json:"TTL"`
+	}
+	ttl := int64(registerTTL.Seconds())
+	if err := etcdCall(ctx, etcd, "/v3/lease/grant", map[string]interface{}{"TTL": strconv.FormatInt(ttl, 10)}, &lease); err != nil {
+		return err
+	}
+	put := map[string]interface{}{
+		"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": lease.ID,
+	}
+	if err := etcdCall(ctx, etcd, "/v3/kv/put", put, nil); err != nil {
+		return err
+	}
+	logger.Info("Registered in etcd", zap.String("endpoint", etcd), zap.String("key", key))
+
+	// Refresh well within the TTL so one lost refresh doesn't drop the key
+	ticker := time.NewTicker(*registerTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Remove the key right away rather than when the lease runs out
+			revokeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := etcdCall(revokeCtx, etcd, "/v3/lease/revoke", map[string]interface{}{"ID": lease.ID}, nil); err != nil {
+				logger.Error("Error revoking etcd lease", zap.Error(err))
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var keepAlive struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		if err := etcdCall(ctx, etcd, "/v3/lease/keepalive", map[string]interface{}{"ID": lease.ID}, &keepAlive); err != nil {
+			return err
+		}
+		// Expired leases are kept alive with no TTL
+		if keepAlive.Result.TTL == "" || keepAlive.Result.TTL == "0" {
+			return errors.New("etcd lease expired")
+		}
+	}
+}
+
+func etcdCall(ctx context.Context, etcd, endpoint string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding etcd request: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, etcd+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating etcd request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling etcd %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error calling etcd %s: %s", endpoint, resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("error decoding etcd %s response: %w", endpoint, err)
+	}
+	return nil
+}
+
+func registerZooKeeperNode(ctx context.Context, servers []string, address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("error parsing register address %q: %w", address, err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("error parsing register port %q: %w", port, err)
+	}
+	data, err := json.Marshal(serversetMember{
+		ServiceEndpoint:     serversetEndpoint{Host: host, Port: portNumber},
+		AdditionalEndpoints: map[string]serversetEndpoint{},
+		Status:              "ALIVE",
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding serverset member: %w", err)
+	}
+
+	// The session timeout acts as the TTL of the ephemeral node
+	conn, sessionEvents, err := zk.Connect(servers, *registerTTL, zk.WithLogger(zkLogger{}))
+	if err != nil {
+		return fmt.Errorf("error connecting to ZooKeeper: %w", err)
+	}
+
+	go func() {
+		defer conn.Close()
+		var node string
+		// Failed registrations are retried without waiting for the next
+		// session event, which may never come while the session holds
+		backoff := minRegisterRetry
+		var retry <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				// Closing the session removes the ephemeral node
+				return
+			case event := <-sessionEvents:
+				if event.State != zk.StateHasSession {
+					continue
+				}
+			case <-retry:
+			}
+			retry = nil
+
+			// Expired sessions take their ephemeral nodes with them
+			if node != "" {
+				if exists, _, err := conn.Exists(node); err == nil && exists {
+					continue
+				}
+			}
+			node, err = createZooKeeperMember(conn, data)
+			if err != nil {
+				logger.Error("Error registering in ZooKeeper", zap.Error(err), zap.Duration("retryIn", backoff))
+				retry = time.After(backoff)
+				backoff = min(backoff*2, maxRegisterRetry)
+				continue
+			}
+			backoff = minRegisterRetry
+			logger.Info("Registered in ZooKeeper", zap.String("node", node))
+		}
+	}()
+	return nil
+}
+
+func createZooKeeperMember(conn *zk.Conn, data []byte) (string, error) {
+	acl := zk.WorldACL(zk.PermAll)
+	parent := ""
+	for _, part := range strings.Split(*registerPrefix, "/") {
+		if part == "" {
+			continue
+		}
+		parent += "/" + part
+		if _, err := conn.Create(parent, nil, 0, acl); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return "", fmt.Errorf("error creating node %s: %w", parent, err)
+		}
+	}
+	// Serverset members are sequential nodes named member_
+	node, err := conn.Create(parent+"/member_", data, zk.FlagEphemeral|zk.FlagSequence, acl)
+	if err != nil {
+		return "", fmt.Errorf("error creating member node: %w", err)
+	}
+	return node, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"go.uber.org/zap"
+)
+
+var (
+	registryConfig      = flag.String("registryConfig", "", "Docker config.json with the credentials of private registries for registry checks (default $DOCKER_CONFIG/config.json or ~/.docker/config.json when it exists)")
+	registryCredentials = flag.String("registryCredentials", "", "Comma separated credentials as registry=user:password for registry checks, overriding those of registryConfig")
+
+	// Encoded X-Registry-Auth headers by registry host
+	registryAuths = map[string]string{}
+)
+
+// dockerConfig is the part of the Docker CLI config with registry credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore string `json:"credsStore"`
+}
+
+func loadRegistryAuths() error {
+	path, explicit := *registryConfig, *registryConfig != ""
+	if !explicit {
+		if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+			path = filepath.Join(dir, "config.json")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".docker", "config.json")
+		}
+	}
+	if path != "" {
+		if err := loadDockerConfigAuths(path); err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return err
+		}
+	}
+
+	if *registryCredentials == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(*registryCredentials, ",") {
+		host, credentials, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		username, password, ok2 := strings.Cut(credentials, ":")
+		if !ok || !ok2 || host == "" {
+			return fmt.Errorf("invalid registry credentials %q, must be registry=user:password", entry)
+		}
+		if err := addRegistryAuth(host, registry.AuthConfig{Username: username, Password: password}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadDockerConfigAuths(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading registry config: %w", err)
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("error parsing registry config %s: %w", path, err)
+	}
+	if config.CredsStore != "" {
+		// Helpers are binaries of the CLI, likely missing where the exporter runs
+		logger.Warn("Registry config uses a credentials store, only its inline credentials are used", zap.String("path", path), zap.String("credsStore", config.CredsStore))
+	}
+
+	for host, entry := range config.Auths {
+		auth := registry.AuthConfig{IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return fmt.Errorf("error decoding credentials of %s in %s: %w", host, path, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		if err := addRegistryAuth(host, auth); err != nil {
+			return err
+		}
+	}
+	logger.Debug("Registry credentials loaded", zap.String("path", path), zap.Int("registries", len(config.Auths)))
+	return nil
+}
+
+func addRegistryAuth(host string, auth registry.AuthConfig) error {
+	host = registryHost(host)
+	auth.ServerAddress = host
+	encoded, err := registry.EncodeAuthConfig(auth)
+	if err != nil {
+		return fmt.Errorf("error encoding credentials of %s: %w", host, err)
+	}
+	registryAuths[host] = encoded
+	return nil
+}
+
+func registryHost(host string) string {
+	// Config keys may be URLs, https://index.docker.io/v1/ for Docker Hub
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
+
+// registryAuth returns the encoded credentials for the registry of the image
+// reference, empty for anonymous access
+func registryAuth(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	return registryAuths[reference.Domain(named)]
+}
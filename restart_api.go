@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Results of restart requests
+	restartResultRestarted   = "restarted"
+	restartResultRateLimited = "rate_limited"
+	restartResultNotFound    = "not_found"
+	restartResultError       = "error"
+)
+
+var (
+	restartAPIEnabled = flag.Bool("restartAPI", false, "Enable POST /api/v1/restart to restart a named container (requires adminToken)")
+	restartMaxPerHour = flag.Int("restartMaxPerHour", 10, "Maximum number of restarts through the restart API per hour, across all containers")
+	restartCooldown   = flag.Duration("restartCooldown", 10*time.Minute, "Minimum time between restarts of the same container through the restart API")
+
+	restartRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prom_restart_requests_total",
+			Help: "Number of container restarts requested through the restart API by container and result, the container is empty when it wasn't found",
+		},
+		[]string{"container_name", "result"},
+	)
+
+	// Restarts done through the API, for rate limiting
+	restartAPIMu   sync.Mutex
+	restartHistory []time.Time
+	restartLast    = map[string]time.Time{} // container ID -> last restart
+)
+
+func init() {
+	exporterRegistry.MustRegister(restartRequests)
+}
+
+// restartResponse is the outcome of a restart request
+type restartResponse struct {
+	ContainerName string `json:"containerName"`
+	Result        string `json:"result"`
+	Error         string `json:"error,omitempty"`
+}
+
+func newRestartHandler(cli *client.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		containerName := query.Get("container")
+		if containerName == "" {
+			http.Error(w, "container is required", http.StatusBadRequest)
+			return
+		}
+		var options typeContainer.StopOptions
+		if timeout := query.Get("timeout"); timeout != "" {
+			seconds, err := strconv.Atoi(timeout)
+			if err != nil {
+				http.Error(w, "timeout must be a number of seconds", http.StatusBadRequest)
+				return
+			}
+			options.Timeout = &seconds
+		}
+
+		// Every request is audited, whatever its outcome. The series are
+		// labelled with the name of the container once found, requests for
+		// made up names would add series otherwise
+		resolvedName := ""
+		audit := func(status int, result string, err error) {
+			restartRequests.WithLabelValues(resolvedName, result).Inc()
+			response := restartResponse{ContainerName: containerName, Result: result}
+			if resolvedName != "" {
+				response.ContainerName = resolvedName
+			}
+			fields := []zap.Field{zap.String("containerName", containerName), zap.String("result", result), zap.String("remote", r.RemoteAddr)}
+			if err != nil {
+				response.Error = err.Error()
+				fields = append(fields, zap.Error(err))
+			}
+			logger.Info("Restart requested", fields...)
+			writeJSON(w, status, response)
+		}
+
+		// Resolved first, the cooldown is per container whether it's asked
+		// for by name or ID
+		container, err := cli.ContainerInspect(r.Context(), containerName)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				audit(http.StatusNotFound, restartResultNotFound, err)
+				return
+			}
+			audit(http.StatusBadGateway, restartResultError, err)
+			return
+		}
+		resolvedName = container.Name
+
+		if !allowRestart(container.ID, time.Now()) {
+			audit(http.StatusTooManyRequests, restartResultRateLimited, nil)
+			return
+		}
+		if err := cli.ContainerRestart(r.Context(), container.ID, options); err != nil {
+			if errdefs.IsNotFound(err) {
+				audit(http.StatusNotFound, restartResultNotFound, err)
+				return
+			}
+			audit(http.StatusBadGateway, restartResultError, err)
+			return
+		}
+		audit(http.StatusOK, restartResultRestarted, nil)
+	}
+}
+
+func allowRestart(containerID string, now time.Time) bool {
+	restartAPIMu.Lock()
+	defer restartAPIMu.Unlock()
+
+	// Drop restarts that slid out of the hour
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(restartHistory) && restartHistory[i].Before(cutoff) {
+		i++
+	}
+	restartHistory = restartHistory[i:]
+	for id, last := range restartLast {
+		if now.Sub(last) >= *restartCooldown {
+			delete(restartLast, id)
+		}
+	}
+
+	if len(restartHistory) >= *restartMaxPerHour {
+		return false
+	}
+	if last, ok := restartLast[containerID]; ok && now.Sub(last) < *restartCooldown {
+		return false
+	}
+	// Failed restarts count too, so a broken container can't be hammered
+	restartHistory = append(restartHistory, now)
+	restartLast[containerID] = now
+	return true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+const (
+	// Reasons a container should be recreated
+	reasonSupersededLocally = "superseded_locally"
+	reasonOutdated          = "outdated"
+)
+
+var (
+	restartCandidatesEnabled = flag.Bool("restartCandidates", false, "Expose containers to recreate for newer images as JSON on /api/v1/restart-candidates (registry checks need the distribution collector)")
+)
+
+// restartCandidate is a container running an image older than its reference
+type restartCandidate struct {
+	ContainerName string   `json:"containerName"`
+	ContainerID   string   `json:"containerId"`
+	Image         string   `json:"image"`
+	ImageID       string   `json:"imageId"`
+	Reasons       []string `json:"reasons"`
+}
+
+func newRestartCandidatesHandler(cli *client.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+		if err != nil {
+			logger.Error("Error listing containers", zap.Error(err))
+			http.Error(w, "error listing containers: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		candidates := []restartCandidate{}
+		imageIDs := map[string]string{}
+		for _, container := range containers {
+			containerName := container.Names[0]
+			info, err := cli.ContainerInspect(ctx, container.ID)
+			if err != nil {
+				logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+				continue
+			}
+			if info.Config == nil || info.Config.Image == "" {
+				continue
+			}
+
+			var reasons []string
+			if superseded, ok := imageSuperseded(ctx, cli, info, imageIDs); ok && superseded {
+				reasons = append(reasons, reasonSupersededLocally)
+			}
+			if imageOutdated(ctx, cli, info) {
+				reasons = append(reasons, reasonOutdated)
+			}
+			if len(reasons) == 0 {
+				continue
+			}
+			candidates = append(candidates, restartCandidate{
+				ContainerName: containerName,
+				ContainerID:   container.ID,
+				Image:         info.Config.Image,
+				ImageID:       info.Image,
+				Reasons:       reasons,
+			})
+		}
+		writeJSON(w, http.StatusOK, candidates)
+	}
+}
+
+func imageOutdated(ctx context.Context, cli *client.Client, info types.ContainerJSON) bool {
+	// Registry digests are cached by the distribution collector under the familiar tag
+	named, err := reference.ParseNormalizedNamed(info.Config.Image)
+	if err != nil {
+		return false
+	}
+	image, _, err := cli.ImageInspectWithRaw(ctx, info.Image)
+	if err != nil {
+		logger.Error("Error inspecting image", zap.String("image", info.Image), zap.Error(err))
+		return false
+	}
+	outdated, known := registryOutdated(reference.FamiliarString(reference.TagNameOnly(named)), image.RepoDigests)
+	return known && outdated
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Window of the restart count
+	restartWindow = time.Hour
+)
+
+var (
+	containerRestartsLastHour = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_restarts_last_hour",
+			Help: "Number of times the container was restarted in the last hour",
+		},
+		[]string{"container_name"},
+	)
+
+	// Restart tracking state, keyed by container ID
+	restartsMu   sync.Mutex
+	restartNames = map[string]string{}
+	restartTimes = map[string][]time.Time{}
+	restartDied  = map[string]bool{}
+)
+
+func init() {
+	c := registerCollector("restarts", collectRestartMetrics, containerRestartsLastHour)
+	c.handleEvent = handleRestartEvent
+	c.dumpState = func() map[string]any {
+		restartsMu.Lock()
+		defer restartsMu.Unlock()
+		return map[string]any{"restartedContainers": len(restartTimes), "trackedContainers": len(restartDied)}
+	}
+}
+
+func handleRestartEvent(msg events.Message) {
+	if msg.Type != events.ContainerEventType {
+		return
+	}
+
+	restartsMu.Lock()
+	defer restartsMu.Unlock()
+
+	id := msg.Actor.ID
+	switch msg.Action {
+	case events.ActionDie:
+		restartDied[id] = true
+	case events.ActionStart:
+		// A start after a die is a restart, by the restart policy or by hand
+		if restartDied[id] {
+			restartNames[id] = eventContainerName(msg)
+			restartTimes[id] = append(restartTimes[id], time.Unix(0, msg.TimeNano))
+		}
+		restartDied[id] = false
+	case events.ActionDestroy:
+		delete(restartNames, id)
+		delete(restartTimes, id)
+		delete(restartDied, id)
+	}
+}
+
+func collectRestartMetrics(_ context.Context, _ *client.Client) {
+	restartsMu.Lock()
+	defer restartsMu.Unlock()
+
+	// Clear old metrics to avoid duplicates
+	containerRestartsLastHour.Reset()
+
+	// Drop restarts that slid out of the window
+	cutoff := time.Now().Add(-restartWindow)
+	for id, times := range restartTimes {
+		i := 0
+		for i < len(times) && times[i].Before(cutoff) {
+			i++
+		}
+		if i == len(times) {
+			delete(restartTimes, id)
+			delete(restartNames, id)
+			continue
+		}
+		restartTimes[id] = times[i:]
+		containerRestartsLastHour.WithLabelValues(restartNames[id]).Set(float64(len(times) - i))
+	}
+}
+
+func eventContainerName(msg events.Message) string {
+	// Same form as the container list, which prefixes names with a slash
+	return "/" + msg.Actor.Attributes["name"]
+}
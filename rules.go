@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Container series silenced for maintenance don't alert
+	rulesSelector = maintenanceLabel + `!="true"`
+)
+
+// ruleFile is a Prometheus rule file
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+// ruleGroup is a group of recording or alerting rules
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+// rule is a recording rule when Record is set, an alerting rule otherwise
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// generatedRule is a rule of a collector, exporter rules have no collector
+type generatedRule struct {
+	collector string
+	rule      rule
+}
+
+// generatedRules returns the rules of all collectors, with metric names as
+// exported after overrides
+func generatedRules() (recording, alerting []generatedRule) {
+	g := func(name string, matchers ...string) string {
+		return metricSelector(exportedName(name, false), append([]string{rulesSelector}, matchers...))
+	}
+	c := func(name string, matchers ...string) string {
+		return metricSelector(exportedName(name, true), append([]string{rulesSelector}, matchers...))
+	}
+	alert := func(name, expr, duration, severity, summary string) rule {
+		return rule{
+			Alert:       name,
+			Expr:        expr,
+			For:         duration,
+			Labels:      map[string]string{"severity": severity},
+			Annotations: map[string]string{"summary": summary},
+		}
+	}
+
+	recording = []generatedRule{
+		{"stats", rule{Record: "instance:docker_container_network_receive_bytes:rate5m", Expr: fmt.Sprintf("sum by (instance) (rate(%s[5m]))", g("docker_container_network_rx_bytes_total"))}},
+		{"stats", rule{Record: "instance:docker_container_network_transmit_bytes:rate5m", Expr: fmt.Sprintf("sum by (instance) (rate(%s[5m]))", g("docker_container_network_tx_bytes_total"))}},
+		{"exits", rule{Record: "instance:docker_container_exits:increase1h", Expr: fmt.Sprintf("sum by (instance, exit_class) (increase(%s[1h]))", c("docker_container_exits_total"))}},
+		{"tenants", rule{Record: "tenant:docker_tenant_cpu_cores:rate5m", Expr: fmt.Sprintf("sum by (tenant) (rate(%s[5m]))", c("docker_tenant_cpu_seconds_total"))}},
+	}
+
+	alerting = []generatedRule{
+		{"", alert("DockerContainerCollectionFailing",
+			fmt.Sprintf("%s > 0", g("docker_container_collection_error")), "15m", "warning",
+			"Metrics of {{ $labels.container_name }} on {{ $labels.instance }} are partial, the {{ $labels.stage }} stage keeps failing")},
+		{"restarts", alert("DockerContainerCrashLooping",
+			fmt.Sprintf("%s > 3", g("docker_container_restarts_last_hour")), "10m", "critical",
+			"{{ $labels.container_name }} on {{ $labels.instance }} restarted {{ $value }} times in the last hour")},
+		{"exits", alert("DockerContainerFailing",
+			fmt.Sprintf("sum by (instance, container_name) (increase(%s[15m])) > 3", c("docker_container_exits_total", `exit_class=~"error|signal"`)), "", "warning",
+			"{{ $labels.container_name }} on {{ $labels.instance }} exited with an error {{ $value }} times in 15 minutes")},
+		{"exits", alert("DockerContainerOOMKilled",
+			fmt.Sprintf("sum by (instance, container_name) (increase(%s[15m])) > 0", c("docker_container_exits_total", `exit_class="oom"`)), "", "warning",
+			"{{ $labels.container_name }} on {{ $labels.instance }} was killed for running out of memory")},
+		{"config", alert("DockerContainerImageOutdated",
+			fmt.Sprintf("%s == 1", g("docker_container_image_superseded_locally")), "1d", "info",
+			"{{ $labels.container_name }} on {{ $labels.instance }} runs an older image than the one pulled for its reference")},
+		{"distribution", alert("DockerContainerImageStale",
+			fmt.Sprintf("%s == 0", g("docker_container_image_up_to_date")), "1d", "info",
+			"{{ $labels.container_name }} on {{ $labels.instance }} runs an image older than the one the registry serves for {{ $labels.image_repo }}")},
+		{"image", alert("DockerContainerArchMismatch",
+			fmt.Sprintf("%s == 1", g("docker_container_arch_mismatch")), "1h", "info",
+			"{{ $labels.container_name }} on {{ $labels.instance }} runs an emulated {{ $labels.image_arch }} image")},
+		{"mounts", alert("DockerBindMountFilesystemFull",
+			fmt.Sprintf("%s / (%s + %s) < 0.1", g("docker_container_bind_mount_free_bytes"), g("docker_container_bind_mount_free_bytes"), g("docker_container_bind_mount_used_bytes")), "15m", "warning",
+			"The filesystem of {{ $labels.source }} mounted in {{ $labels.container_name }} on {{ $labels.instance }} is over 90% full")},
+		{"mounts", alert("DockerTmpfsFull",
+			fmt.Sprintf("%s / %s > 0.9", g("docker_container_tmpfs_used_bytes"), g("docker_container_tmpfs_size_bytes")), "15m", "warning",
+			"tmpfs {{ $labels.mountpoint }} of {{ $labels.container_name }} on {{ $labels.instance }} is over 90% full")},
+		{"prune", alert("DockerReclaimableSpaceHigh",
+			fmt.Sprintf("sum by (instance) (%s) > 50 * 1024^3", g("docker_prune_reclaimable_bytes", `type!~".+_all"`)), "1d", "info",
+			"{{ $labels.instance }} has {{ $value | humanize1024 }}B of unused Docker objects to prune")},
+		{"cgroup", alert("DockerContainerMemoryPressure",
+			fmt.Sprintf("%s > 10", g("docker_container_pressure_percent", `resource="memory"`, `kind="full"`, `window="60s"`)), "10m", "warning",
+			"All tasks of {{ $labels.container_name }} on {{ $labels.instance }} are stalled on memory {{ $value }}% of the time")},
+		{"dind", alert("DockerNestedDaemonDown",
+			fmt.Sprintf("%s == 0", g("docker_dind_daemon_up")), "5m", "warning",
+			"The nested Docker daemon of {{ $labels.parent_container }} on {{ $labels.instance }} doesn't answer")},
+	}
+	return recording, alerting
+}
+
+func writeRules(w io.Writer, enabled []*collector) error {
+	recording, alerting := generatedRules()
+	enabledRules := func(rules []generatedRule) []rule {
+		var result []rule
+		for _, r := range rules {
+			if r.collector == "" || collectorEnabled(enabled, r.collector) {
+				result = append(result, r.rule)
+			}
+		}
+		return result
+	}
+
+	var file ruleFile
+	if rules := enabledRules(recording); len(rules) > 0 {
+		file.Groups = append(file.Groups, ruleGroup{Name: "docker-prom.rules", Rules: rules})
+	}
+	file.Groups = append(file.Groups, ruleGroup{Name: "docker-prom.alerts", Rules: enabledRules(alerting)})
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
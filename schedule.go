@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	alignInterval = flag.Bool("alignInterval", false, "Align collections to wall clock multiples of the interval (e.g. :00 and :30 for 30s)")
+	alignJitter   = flag.Duration("alignJitter", 0, "Upper bound of the per host offset added to aligned collections, so hosts don't hit shared registries at once")
+)
+
+// jitterOffset is a stable offset below max, the same host always collects at
+// the same point after the boundary
+func jitterOffset(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Warn("Error getting hostname for collection jitter", zap.Error(err))
+	}
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+func nextCollection(now time.Time, interval time.Duration, align bool, offset time.Duration) time.Duration {
+	if !align || interval <= 0 {
+		return interval
+	}
+	// Truncate works on absolute time, so all hosts share the boundaries
+	next := now.Truncate(interval).Add(offset)
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+	return next.Sub(now)
+}
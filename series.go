@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Most labels a tracked series can have
+	maxSeriesLabels = 4
+)
+
+// seriesKey identifies a series by its label values, an array so building it
+// doesn't allocate
+type seriesKey [maxSeriesLabels]string
+
+// gaugeSeries tracks the series of gauges sharing the same labels across
+// cycles. Deleting only the series that weren't set again, instead of resetting
+// the gauges, keeps the series of running containers instead of reallocating
+// them every cycle.
+type gaugeSeries struct {
+	gauges  []*prometheus.GaugeVec
+	last    map[seriesKey]int
+	current map[seriesKey]int
+}
+
+func newGaugeSeries(gauges ...*prometheus.GaugeVec) *gaugeSeries {
+	return &gaugeSeries{gauges: gauges, last: map[seriesKey]int{}, current: map[seriesKey]int{}}
+}
+
+// set sets a series of one of the gauges, labels may be reused by the caller
+func (s *gaugeSeries) set(gauge *prometheus.GaugeVec, value float64, labels []string) {
+	gauge.WithLabelValues(labels...).Set(value)
+	var key seriesKey
+	copy(key[:], labels)
+	s.current[key] = len(labels)
+}
+
+// sweep deletes the series not set since the last sweep
+func (s *gaugeSeries) sweep() {
+	for key, n := range s.last {
+		if _, ok := s.current[key]; ok {
+			continue
+		}
+		for _, g := range s.gauges {
+			g.DeleteLabelValues(key[:n]...)
+		}
+	}
+	// Swap the maps so neither is reallocated
+	clear(s.last)
+	s.last, s.current = s.current, s.last
+}
+
+// counterSeries is a vector of counters whose values are read from somewhere
+// else, like the cumulative usage of containers, exposed with the counter type.
+// Series not set since the last sweep are deleted like those of gaugeSeries.
+type counterSeries struct {
+	desc *prometheus.Desc
+
+	mu     sync.Mutex
+	values map[seriesKey]*counterValue
+}
+
+// counterValue is the value of a series and whether it was set this cycle
+type counterValue struct {
+	labels int
+	value  float64
+	set    bool
+}
+
+func newCounterSeries(name, help string, labels ...string) *counterSeries {
+	return &counterSeries{
+		desc:   prometheus.NewDesc(name, help, labels, nil),
+		values: map[seriesKey]*counterValue{},
+	}
+}
+
+// set sets a series to the value read, labels may be reused by the caller
+func (s *counterSeries) set(value float64, labels []string) {
+	var key seriesKey
+	copy(key[:], labels)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		v = &counterValue{labels: len(labels)}
+		s.values[key] = v
+	}
+	v.value, v.set = value, true
+}
+
+// sweep deletes the series not set since the last sweep
+func (s *counterSeries) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, v := range s.values {
+		if !v.set {
+			delete(s.values, key)
+			continue
+		}
+		v.set = false
+	}
+}
+
+// Reset deletes all series
+func (s *counterSeries) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.values)
+}
+
+func (s *counterSeries) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+func (s *counterSeries) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, v := range s.values {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.CounterValue, v.value, key[:v.labels]...)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shortLivedThreshold = flag.Duration("shortLivedThreshold", time.Minute, "Runs shorter than this are captured by the shortlived collector")
+	shortLivedRetention = flag.Duration("shortLivedRetention", time.Hour, "How long captured short-lived runs are exported after they ended")
+
+	shortLivedLastRun = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_last_run_timestamp_seconds",
+			Help: "Start time of the last short-lived run of the container",
+		},
+		[]string{"container_name", "image"},
+	)
+	shortLivedDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_last_run_duration_seconds",
+			Help: "Duration of the last short-lived run of the container",
+		},
+		[]string{"container_name", "image"},
+	)
+	shortLivedExitCode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_last_run_exit_code",
+			Help: "Exit code of the last short-lived run of the container",
+		},
+		[]string{"container_name", "image"},
+	)
+
+	// Short-lived run tracking state
+	shortLivedMu     sync.Mutex
+	shortLivedStarts = map[string]time.Time{} // container ID -> start time
+	shortLivedRuns   = map[string]containerRun{}
+)
+
+// containerRun is a finished run of a container
+type containerRun struct {
+	name     string
+	image    string
+	started  time.Time
+	ended    time.Time
+	exitCode int
+}
+
+func init() {
+	c := registerCollector("shortlived", collectShortLivedMetrics, shortLivedLastRun, shortLivedDuration, shortLivedExitCode)
+	c.handleEvent = handleShortLivedEvent
+	c.dumpState = func() map[string]any {
+		shortLivedMu.Lock()
+		defer shortLivedMu.Unlock()
+		return map[string]any{"running": len(shortLivedStarts), "capturedRuns": len(shortLivedRuns)}
+	}
+}
+
+func handleShortLivedEvent(msg events.Message) {
+	if msg.Type != events.ContainerEventType {
+		return
+	}
+
+	shortLivedMu.Lock()
+	defer shortLivedMu.Unlock()
+
+	id := msg.Actor.ID
+	at := time.Unix(0, msg.TimeNano)
+	switch msg.Action {
+	case events.ActionStart:
+		shortLivedStarts[id] = at
+	case events.ActionDie:
+		// Runs that started before the exporter have no known start
+		started, ok := shortLivedStarts[id]
+		delete(shortLivedStarts, id)
+		if !ok || at.Sub(started) > *shortLivedThreshold {
+			return
+		}
+		exitCode, _ := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+		name := eventContainerName(msg)
+		shortLivedRuns[name] = containerRun{
+			name:     name,
+			image:    msg.Actor.Attributes["image"],
+			started:  started,
+			ended:    at,
+			exitCode: exitCode,
+		}
+	case events.ActionDestroy:
+		delete(shortLivedStarts, id)
+	}
+}
+
+func collectShortLivedMetrics(_ context.Context, _ *client.Client) {
+	shortLivedMu.Lock()
+	defer shortLivedMu.Unlock()
+
+	// Clear old metrics to avoid duplicates
+	shortLivedLastRun.Reset()
+	shortLivedDuration.Reset()
+	shortLivedExitCode.Reset()
+
+	cutoff := time.Now().Add(-*shortLivedRetention)
+	for name, run := range shortLivedRuns {
+		if run.ended.Before(cutoff) {
+			delete(shortLivedRuns, name)
+			continue
+		}
+		shortLivedLastRun.WithLabelValues(run.name, run.image).Set(float64(run.started.Unix()))
+		shortLivedDuration.WithLabelValues(run.name, run.image).Set(run.ended.Sub(run.started).Seconds())
+		shortLivedExitCode.WithLabelValues(run.name, run.image).Set(float64(run.exitCode))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// snapshot holds the metrics gathered at the end of the last collection, so
+// scrapes and file writes never wait for a collection or see one half done
+type snapshot struct {
+	gatherer prometheus.Gatherer
+
+	mu       sync.RWMutex
+	families []*dto.MetricFamily
+	err      error
+}
+
+func newSnapshot(gatherer prometheus.Gatherer) *snapshot {
+	return &snapshot{gatherer: gatherer}
+}
+
+func (s *snapshot) update() {
+	families, err := s.gatherer.Gather()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.families, s.err = families, err
+}
+
+func (s *snapshot) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// Readers may filter the slice, the families themselves are shared
+	return slices.Clone(s.families), s.err
+}
+
+func (o *fileOutput) runWriter(files map[string]prometheus.Gatherer, writes <-chan time.Time) {
+	// Writes happen off the collection loop, a slow filesystem only delays
+	// the files
+	for collectedAt := range writes {
+		if err := o.write(files, collectedAt); err != nil {
+			logger.Error("Error writing metrics to file", zap.Error(err))
+		}
+	}
+}
+
+func requestWrite(writes chan time.Time, collectedAt time.Time) {
+	// The writer is still busy when a write is pending, the newer snapshot
+	// replaces it
+	select {
+	case <-writes:
+		logger.Warn("Metrics file write still running, skipping a cycle")
+	default:
+	}
+	writes <- collectedAt
+}
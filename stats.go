@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// Interface label value used when network counters are aggregated
+	allInterfaces = "all"
+)
+
+var (
+	aggregateNetwork = flag.Bool("aggregateNetwork", false, "Sum network counters over all interfaces of a container instead of reporting each interface")
+	enableStats      = flag.Bool("enableStats", false, "Enable the stats collector (CPU, memory, network and block I/O per running container) in addition to -collectors")
+	statsConcurrency = flag.Int("statsConcurrency", 8, "Number of containers whose stats are requested at the same time, each request takes a while")
+
+	// Resource usage per container
+	containerCPUUsage    = newCounterSeries("docker_container_cpu_usage_seconds_total", "CPU time consumed by the container", "container_name", "container_id")
+	containerMemoryUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_usage_bytes",
+			Help: "Memory used by the container without reclaimable page cache, as docker stats reports it",
+		},
+		[]string{"container_name", "container_id"},
+	)
+	containerMemoryLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_limit_bytes",
+			Help: "Memory limit of the container, the host memory when unlimited",
+		},
+		[]string{"container_name", "container_id"},
+	)
+	containerBlkioRead  = newCounterSeries("docker_container_blkio_read_bytes_total", "Number of bytes read from block devices by the container", "container_name", "container_id")
+	containerBlkioWrite = newCounterSeries("docker_container_blkio_write_bytes_total", "Number of bytes written to block devices by the container", "container_name", "container_id")
+
+	usageGauges   = []*prometheus.GaugeVec{containerMemoryUsage, containerMemoryLimit}
+	usageSeries   = newGaugeSeries(usageGauges...)
+	usageCounters = []*counterSeries{containerCPUUsage, containerBlkioRead, containerBlkioWrite}
+
+	// Network counters per container and interface
+	containerNetworkRxBytes   = newNetworkCounter("rx_bytes", "Number of bytes received")
+	containerNetworkRxPackets = newNetworkCounter("rx_packets", "Number of packets received")
+	containerNetworkRxErrors  = newNetworkCounter("rx_errors", "Number of receive errors")
+	containerNetworkRxDropped = newNetworkCounter("rx_dropped", "Number of received packets dropped")
+	containerNetworkTxBytes   = newNetworkCounter("tx_bytes", "Number of bytes sent")
+	containerNetworkTxPackets = newNetworkCounter("tx_packets", "Number of packets sent")
+	containerNetworkTxErrors  = newNetworkCounter("tx_errors", "Number of transmit errors")
+	containerNetworkTxDropped = newNetworkCounter("tx_dropped", "Number of sent packets dropped")
+
+	networkCounters = []*counterSeries{
+		containerNetworkRxBytes, containerNetworkRxPackets, containerNetworkRxErrors, containerNetworkRxDropped,
+		containerNetworkTxBytes, containerNetworkTxPackets, containerNetworkTxErrors, containerNetworkTxDropped,
+	}
+
+	// CPU usage in nanoseconds and when it was read, by daemon and container ID
+	cpuSamplesMu sync.Mutex
+	cpuSamples   = map[string]map[string]cpuSample{}
+
+	// Stats of the current cycle by container ID
+	statsCacheMu sync.Mutex
+	statsCache   = map[string]typeContainer.StatsResponse{}
+)
+
+// cpuSample is the cumulative CPU usage of a container at a point in time
+type cpuSample struct {
+	usage uint64
+	at    time.Time
+}
+
+// containerUsage is what a running container uses
+type containerUsage struct {
+	id     string
+	name   string
+	cores  float64
+	memory uint64
+}
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range usageGauges {
+		metrics = append(metrics, g)
+	}
+	for _, c := range usageCounters {
+		metrics = append(metrics, c)
+	}
+	for _, c := range networkCounters {
+		metrics = append(metrics, c)
+	}
+	metrics = append(metrics, containerTopCPU, containerTopMemory, containersCPU, containersMemory, containerCPUDistribution, containerMemoryDistribution, containerMemoryUtilization, containerCPUUtilization)
+	registerCollector("stats", collectStatsMetrics, metrics...)
+}
+
+func newNetworkCounter(name, help string) *counterSeries {
+	return newCounterSeries("docker_container_network_"+name+"_total", help+" by the container", "container_name", "container_id", "interface")
+}
+
+func collectStatsMetrics(ctx context.Context, cli *client.Client) {
+	// Stats are only available for running containers
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	usages := containerUsages(ctx, cli, containers)
+	setUsageHistograms(usages)
+	setUtilizationMetrics(ctx, cli, usages)
+
+	// Per container series of the top containers only, when limited
+	top := topContainers(usages)
+
+	// Label values are reused for every series, with 800 containers the
+	// allocations add up
+	labels := make([]string, 3)
+	for _, usage := range usages {
+		if top != nil && !top[usage.id] {
+			continue
+		}
+		// Fetched for the usage, this cycle's stats are cached
+		stats, err := containerStats(ctx, cli, usage.id)
+		if err != nil {
+			continue
+		}
+
+		labels[0], labels[1] = usage.name, shortID(usage.id)
+		setUsageMetrics(labels[:2], stats)
+		if *aggregateNetwork {
+			labels[2] = allInterfaces
+			setNetworkMetrics(labels, sumNetworkStats(stats.Networks))
+			continue
+		}
+		for iface, network := range stats.Networks {
+			labels[2] = iface
+			setNetworkMetrics(labels, network)
+		}
+	}
+
+	// Remove series of containers and interfaces that went away
+	usageSeries.sweep()
+	for _, c := range usageCounters {
+		c.sweep()
+	}
+	for _, c := range networkCounters {
+		c.sweep()
+	}
+}
+
+func containerUsages(ctx context.Context, cli *client.Client, containers []types.Container) []containerUsage {
+	prefetchStats(ctx, cli, containers)
+
+	cpuSamplesMu.Lock()
+	defer cpuSamplesMu.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]cpuSample, len(containers))
+	usages := make([]containerUsage, 0, len(containers))
+	for _, container := range containers {
+		containerName := container.Names[0]
+		stats, err := containerStats(ctx, cli, container.ID)
+		if err != nil {
+			logger.Error("Error getting stats for container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageStats, err)
+			continue
+		}
+
+		// One-shot stats have no previous sample, the rate is against the
+		// last collection and zero for new containers
+		usage := containerUsage{id: container.ID, name: containerName, memory: memoryUsage(stats)}
+		sample := cpuSample{usage: stats.CPUStats.CPUUsage.TotalUsage, at: now}
+		if last, ok := cpuSamples[cli.DaemonHost()][container.ID]; ok && sample.usage >= last.usage && now.After(last.at) {
+			usage.cores = float64(sample.usage-last.usage) / float64(now.Sub(last.at))
+		}
+		seen[container.ID] = sample
+		usages = append(usages, usage)
+	}
+	cpuSamples[cli.DaemonHost()] = seen
+	return usages
+}
+
+func prefetchStats(ctx context.Context, cli *client.Client, containers []types.Container) {
+	// A stats request takes a second or two per container, fetch them
+	// concurrently into the cache, errors are reported when read from it
+	sem := make(chan struct{}, max(*statsConcurrency, 1))
+	var wg sync.WaitGroup
+	for _, container := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			containerStats(ctx, cli, container.ID)
+		}()
+	}
+	wg.Wait()
+}
+
+func setUsageMetrics(labels []string, stats typeContainer.StatsResponse) {
+	containerCPUUsage.set(float64(stats.CPUStats.CPUUsage.TotalUsage)/1e9, labels)
+	usageSeries.set(containerMemoryUsage, float64(memoryUsage(stats)), labels)
+	usageSeries.set(containerMemoryLimit, float64(stats.MemoryStats.Limit), labels)
+
+	// Ops are Read and Write on cgroup v1, read and write on v2
+	var read, write uint64
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			read += entry.Value
+		case "write":
+			write += entry.Value
+		}
+	}
+	containerBlkioRead.set(float64(read), labels)
+	containerBlkioWrite.set(float64(write), labels)
+}
+
+func setNetworkMetrics(labels []string, network typeContainer.NetworkStats) {
+	containerNetworkRxBytes.set(float64(network.RxBytes), labels)
+	containerNetworkRxPackets.set(float64(network.RxPackets), labels)
+	containerNetworkRxErrors.set(float64(network.RxErrors), labels)
+	containerNetworkRxDropped.set(float64(network.RxDropped), labels)
+	containerNetworkTxBytes.set(float64(network.TxBytes), labels)
+	containerNetworkTxPackets.set(float64(network.TxPackets), labels)
+	containerNetworkTxErrors.set(float64(network.TxErrors), labels)
+	containerNetworkTxDropped.set(float64(network.TxDropped), labels)
+}
+
+func resetStatsCache() {
+	statsCacheMu.Lock()
+	defer statsCacheMu.Unlock()
+	clear(statsCache)
+}
+
+func containerStats(ctx context.Context, cli *client.Client, containerID string) (typeContainer.StatsResponse, error) {
+	// Collectors of the same cycle share the stats of a container
+	statsCacheMu.Lock()
+	cached, ok := statsCache[containerID]
+	statsCacheMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var stats typeContainer.StatsResponse
+
+	// One-shot stats skip waiting for a second sample
+	resp, err := cli.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return stats, fmt.Errorf("error requesting stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return stats, fmt.Errorf("error decoding stats: %w", err)
+	}
+
+	statsCacheMu.Lock()
+	defer statsCacheMu.Unlock()
+	statsCache[containerID] = stats
+	return stats, nil
+}
+
+func memoryUsage(stats typeContainer.StatsResponse) uint64 {
+	// Same as docker stats, page cache that can be reclaimed doesn't count.
+	// The key is inactive_file on cgroup v2 and total_inactive_file on v1.
+	inactive, ok := stats.MemoryStats.Stats["inactive_file"]
+	if !ok {
+		inactive = stats.MemoryStats.Stats["total_inactive_file"]
+	}
+	if inactive > stats.MemoryStats.Usage {
+		return 0
+	}
+	return stats.MemoryStats.Usage - inactive
+}
+
+func sumNetworkStats(networks map[string]typeContainer.NetworkStats) typeContainer.NetworkStats {
+	var total typeContainer.NetworkStats
+	for _, network := range networks {
+		total.RxBytes += network.RxBytes
+		total.RxPackets += network.RxPackets
+		total.RxErrors += network.RxErrors
+		total.RxDropped += network.RxDropped
+		total.TxBytes += network.TxBytes
+		total.TxPackets += network.TxPackets
+		total.TxErrors += network.TxErrors
+		total.TxDropped += network.TxDropped
+	}
+	return total
+}
+
+func shortID(id string) string {
+	// Same 12 character form the docker CLI shows
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Placeholder of secret values, as in the Prometheus status pages
+	redactedSecret = "<secret>"
+)
+
+var (
+	// Flags whose values are never shown
+	secretFlags = map[string]bool{
+		"adminToken":          true,
+		"proxyRules":          true,
+		"registryCredentials": true,
+	}
+)
+
+// configStatus is the effective configuration of the exporter
+type configStatus struct {
+	ConfigFile string            `json:"configFile"`
+	Collectors []string          `json:"collectors"`
+	Flags      map[string]string `json:"flags"`
+	Sources    map[string]string `json:"sources"`
+}
+
+func newConfigStatusHandler(enabled []*collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := configStatus{
+			ConfigFile: *configFile,
+			Collectors: []string{},
+			Flags:      map[string]string{},
+			Sources:    map[string]string{},
+		}
+		for _, c := range enabled {
+			status.Collectors = append(status.Collectors, c.name)
+		}
+		flag.VisitAll(func(f *flag.Flag) {
+			value := f.Value.String()
+			if secretFlags[f.Name] && value != "" {
+				value = redactedSecret
+			} else {
+				value = redactUserinfo(value)
+			}
+			status.Flags[f.Name] = value
+			status.Sources[f.Name] = flagSources[f.Name]
+		})
+
+		// Same envelope as the Prometheus API
+		writeJSON(w, http.StatusOK, map[string]any{"status": "success", "data": status})
+	}
+}
+
+func redactUserinfo(value string) string {
+	// URL flags, alone or in lists like registerEndpoints, may carry
+	// credentials as user:password@
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		u, err := url.Parse(strings.TrimSpace(item))
+		if err != nil || u.User == nil {
+			continue
+		}
+		items[i] = strings.Replace(item, u.User.String()+"@", redactedSecret+"@", 1)
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	containerLayerInodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_writable_layer_inodes",
+			Help: "Number of inodes used by the writable layer of the container",
+		},
+		[]string{"container_name", "driver"},
+	)
+	containerLayerInodesFree = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_writable_layer_inodes_free",
+			Help: "Number of free inodes on the filesystem holding the writable layer of the container",
+		},
+		[]string{"container_name", "driver"},
+	)
+	containerLayers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_layer_directories",
+			Help: "Number of layer directories stacked for the container, including the writable layer",
+		},
+		[]string{"container_name", "driver"},
+	)
+
+	storageGauges = []*prometheus.GaugeVec{containerLayerInodes, containerLayerInodesFree, containerLayers}
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range storageGauges {
+		metrics = append(metrics, g)
+	}
+	c := registerCollector("storage", collectStorageMetrics, metrics...)
+	c.hostFiles = true
+}
+
+func collectStorageMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range storageGauges {
+		g.Reset()
+	}
+
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	for _, container := range containers {
+		containerName := container.Names[0]
+
+		// Layer locations are only available from the full inspect
+		info, err := cli.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageInspect, err)
+			continue
+		}
+
+		driver := info.GraphDriver.Name
+		switch driver {
+		case "overlay2", "overlay":
+			setOverlayLayerMetrics(containerName, driver, info.GraphDriver.Data)
+		case "zfs":
+			setZFSLayerMetrics(containerName, info.GraphDriver.Data)
+		default:
+			logger.Debug("Storage driver not supported for layer metrics", zap.String("containerName", containerName), zap.String("driver", driver))
+		}
+	}
+}
+
+func setOverlayLayerMetrics(containerName, driver string, data map[string]string) {
+	upperDir := data["UpperDir"]
+	if upperDir == "" {
+		return
+	}
+
+	// Overlay has no per-layer inode accounting, count the entries of the upper dir
+	inodes := 0
+	err := filepath.WalkDir(hostPath(upperDir), func(_ string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		inodes++
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting writable layer inodes", zap.String("containerName", containerName), zap.Error(err))
+		return
+	}
+	containerLayerInodes.WithLabelValues(containerName, driver).Set(float64(inodes))
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(hostPath(upperDir), &stat); err != nil {
+		logger.Error("Error getting writable layer filesystem stats", zap.String("containerName", containerName), zap.Error(err))
+	} else {
+		containerLayerInodesFree.WithLabelValues(containerName, driver).Set(float64(stat.Ffree))
+	}
+
+	// Lower dirs are colon separated, from the init layer down to the image base
+	layers := 1
+	if lowerDir := data["LowerDir"]; lowerDir != "" {
+		layers += len(strings.Split(lowerDir, ":"))
+	}
+	containerLayers.WithLabelValues(containerName, driver).Set(float64(layers))
+}
+
+func setZFSLayerMetrics(containerName string, data map[string]string) {
+	mountpoint := data["Mountpoint"]
+	if mountpoint == "" {
+		return
+	}
+
+	// Each container is a dataset, statfs reports its own object counts
+	var stat unix.Statfs_t
+	if err := unix.Statfs(hostPath(mountpoint), &stat); err != nil {
+		logger.Error("Error getting writable layer filesystem stats", zap.String("containerName", containerName), zap.Error(err))
+		return
+	}
+	containerLayerInodes.WithLabelValues(containerName, "zfs").Set(float64(stat.Files - stat.Ffree))
+	containerLayerInodesFree.WithLabelValues(containerName, "zfs").Set(float64(stat.Ffree))
+}
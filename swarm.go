@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"math"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	swarmLeaderOnly = flag.Bool("swarmLeaderOnly", false, "Only export swarm metrics from the exporter running on the raft leader, for one exporter per manager")
+
+	// Whether this exporter runs on the raft leader, as of the last cycle
+	swarmLeader atomic.Bool
+
+	swarmExporterLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_prom_swarm_leader",
+			Help: "Whether this exporter runs on the swarm raft leader and exports the swarm metrics",
+		},
+	)
+
+	// All states a service update can be in
+	serviceUpdateStates = []swarm.UpdateState{
+		swarm.UpdateStateUpdating,
+		swarm.UpdateStatePaused,
+		swarm.UpdateStateCompleted,
+		swarm.UpdateStateRollbackStarted,
+		swarm.UpdateStateRollbackPaused,
+		swarm.UpdateStateRollbackCompleted,
+	}
+
+	// Service update and rollback status
+	serviceUpdateState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_service_update_state",
+			Help: "Whether the last update of the service is in the given state (1) or not (0)",
+		},
+		[]string{"service_name", "state"},
+	)
+	serviceUpdateStarted = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_service_update_started_timestamp_seconds",
+			Help: "Time the last update of the service started",
+		},
+		[]string{"service_name"},
+	)
+	serviceUpdateCompleted = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_service_update_completed_timestamp_seconds",
+			Help: "Time the last update of the service completed",
+		},
+		[]string{"service_name"},
+	)
+	serviceUpdateMessageInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_service_update_message_info",
+			Help: "Hash of the status message of the last update of the service, changes with every new message",
+		},
+		[]string{"service_name", "message_hash"},
+	)
+
+	// Overlay network address utilization
+	networkSubnetSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_network_subnet_addresses",
+			Help: "Number of usable addresses in the subnet of the overlay network",
+		},
+		[]string{"network_name", "subnet"},
+	)
+	networkAllocatedIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_network_allocated_addresses",
+			Help: "Number of addresses allocated to service VIPs, tasks and load balancers in the subnet of the overlay network",
+		},
+		[]string{"network_name", "subnet"},
+	)
+
+	// Stack aggregates, over the services of each stack
+	stackServices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_stack_services",
+			Help: "Number of services in the stack",
+		},
+		[]string{"stack"},
+	)
+	stackReplicasDesired = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_stack_replicas_desired",
+			Help: "Number of desired tasks over all services of the stack",
+		},
+		[]string{"stack"},
+	)
+	stackReplicasRunning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_stack_replicas_running",
+			Help: "Number of running tasks over all services of the stack",
+		},
+		[]string{"stack"},
+	)
+
+	// Secrets and configs referenced by services
+	secretServiceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_secret_service_info",
+			Help: "Services referencing the secret",
+		},
+		[]string{"secret_name", "service_name"},
+	)
+	secretServices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_secret_services",
+			Help: "Number of services referencing the secret, 0 for unused secrets",
+		},
+		[]string{"secret_name"},
+	)
+	configServiceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_config_service_info",
+			Help: "Services referencing the config",
+		},
+		[]string{"config_name", "service_name"},
+	)
+	configServices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_config_services",
+			Help: "Number of services referencing the config, 0 for unused configs",
+		},
+		[]string{"config_name"},
+	)
+
+	// Node changes, from the events only managers receive
+	nodeAvailabilityChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_swarm_node_availability_changes_total",
+			Help: "Number of node availability transitions, e.g. active to drain",
+		},
+		[]string{"node_name", "from", "to"},
+	)
+	leaderChanges = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "docker_swarm_leader_changes_total",
+			Help: "Number of swarm leader changes",
+		},
+	)
+	lastLeaderChange = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_swarm_last_leader_change_timestamp_seconds",
+			Help: "Time of the last swarm leader change",
+		},
+	)
+
+	// All swarm metrics, reset every cycle
+	swarmGauges = []*prometheus.GaugeVec{
+		serviceUpdateState,
+		serviceUpdateStarted,
+		serviceUpdateCompleted,
+		serviceUpdateMessageInfo,
+		networkSubnetSize,
+		networkAllocatedIPs,
+		stackServices,
+		stackReplicasDesired,
+		stackReplicasRunning,
+		secretServiceInfo,
+		secretServices,
+		configServiceInfo,
+		configServices,
+	}
+)
+
+func init() {
+	var metrics []prometheus.Collector
+	for _, g := range swarmGauges {
+		metrics = append(metrics, g)
+	}
+	metrics = append(metrics, nodeAvailabilityChanges, leaderChanges, lastLeaderChange, swarmExporterLeader)
+	c := registerCollector("swarm", collectSwarmMetrics, metrics...)
+	c.handleEvent = handleSwarmEvent
+	c.dumpState = func() map[string]any {
+		return map[string]any{"leaderOnly": *swarmLeaderOnly, "leader": swarmLeader.Load()}
+	}
+}
+
+func collectSwarmMetrics(ctx context.Context, cli *client.Client) {
+	// Clear old metrics to avoid duplicates
+	for _, g := range swarmGauges {
+		g.Reset()
+	}
+
+	// Cluster state is only available from managers
+	info, err := cli.Info(ctx)
+	if err != nil {
+		logger.Error("Error getting Docker info", zap.Error(err))
+		return
+	}
+	if !info.Swarm.ControlAvailable {
+		logger.Debug("Not a swarm manager, skipping swarm metrics")
+		swarmLeader.Store(false)
+		swarmExporterLeader.Set(0)
+		return
+	}
+	if *swarmLeaderOnly && !isSwarmLeader(ctx, cli, info.Swarm.NodeID) {
+		logger.Debug("Not the swarm leader, skipping swarm metrics")
+		return
+	}
+
+	services, err := cli.ServiceList(ctx, types.ServiceListOptions{Status: true})
+	if err != nil {
+		logger.Error("Error listing services", zap.Error(err))
+		return
+	}
+	for _, service := range services {
+		setServiceUpdateMetrics(service)
+	}
+
+	setStackMetrics(services)
+	setSecretMetrics(ctx, cli, services)
+
+	setOverlayNetworkMetrics(ctx, cli, services)
+}
+
+func isSwarmLeader(ctx context.Context, cli *client.Client, nodeID string) bool {
+	node, _, err := cli.NodeInspectWithRaw(ctx, nodeID)
+	if err != nil {
+		logger.Error("Error inspecting swarm node", zap.String("nodeID", nodeID), zap.Error(err))
+	}
+	// Treat errors as not leading, a missed cycle beats duplicate series
+	leader := err == nil && node.ManagerStatus != nil && node.ManagerStatus.Leader
+	if leader != swarmLeader.Swap(leader) {
+		logger.Info("Swarm leadership changed", zap.Bool("leader", leader))
+	}
+	if leader {
+		swarmExporterLeader.Set(1)
+	} else {
+		swarmExporterLeader.Set(0)
+	}
+	return leader
+}
+
+func handleSwarmEvent(msg events.Message) {
+	if msg.Type != events.NodeEventType || msg.Action != events.ActionUpdate {
+		return
+	}
+	// Every manager receives node events, only the leader counts them
+	if *swarmLeaderOnly && !swarmLeader.Load() {
+		return
+	}
+
+	// Update events carry the old and new value of changed node attributes
+	attributes := msg.Actor.Attributes
+	if to := attributes["availability.new"]; to != "" {
+		nodeAvailabilityChanges.WithLabelValues(attributes["name"], attributes["availability.old"], to).Inc()
+	}
+	if attributes["leader.new"] == "true" {
+		leaderChanges.Inc()
+		lastLeaderChange.Set(float64(time.Unix(0, msg.TimeNano).Unix()))
+	}
+}
+
+func setServiceUpdateMetrics(service swarm.Service) {
+	serviceName := service.Spec.Name
+
+	// Services that were never updated have no update status
+	status := service.UpdateStatus
+	if status == nil {
+		return
+	}
+
+	for _, state := range serviceUpdateStates {
+		value := 0.0
+		if status.State == state {
+			value = 1
+		}
+		serviceUpdateState.WithLabelValues(serviceName, string(state)).Set(value)
+	}
+	if status.StartedAt != nil {
+		serviceUpdateStarted.WithLabelValues(serviceName).Set(float64(status.StartedAt.Unix()))
+	}
+	if status.CompletedAt != nil {
+		serviceUpdateCompleted.WithLabelValues(serviceName).Set(float64(status.CompletedAt.Unix()))
+	}
+	if status.Message != "" {
+		serviceUpdateMessageInfo.WithLabelValues(serviceName, shortHash(status.Message)).Set(1)
+	}
+}
+
+func setStackMetrics(services []swarm.Service) {
+	for _, service := range services {
+		// Services deployed with docker stack deploy carry their stack name
+		stack := service.Spec.Labels["com.docker.stack.namespace"]
+		if stack == "" {
+			continue
+		}
+		stackServices.WithLabelValues(stack).Inc()
+		if status := service.ServiceStatus; status != nil {
+			stackReplicasDesired.WithLabelValues(stack).Add(float64(status.DesiredTasks))
+			stackReplicasRunning.WithLabelValues(stack).Add(float64(status.RunningTasks))
+		}
+	}
+}
+
+func setSecretMetrics(ctx context.Context, cli *client.Client, services []swarm.Service) {
+	// References by name, as shown by docker service inspect
+	secretRefs := map[string]int{}
+	configRefs := map[string]int{}
+	for _, service := range services {
+		spec := service.Spec.TaskTemplate.ContainerSpec
+		if spec == nil {
+			continue
+		}
+		for _, secret := range spec.Secrets {
+			secretServiceInfo.WithLabelValues(secret.SecretName, service.Spec.Name).Set(1)
+			secretRefs[secret.SecretName]++
+		}
+		for _, config := range spec.Configs {
+			configServiceInfo.WithLabelValues(config.ConfigName, service.Spec.Name).Set(1)
+			configRefs[config.ConfigName]++
+		}
+	}
+
+	// List all of them so unused ones show up with a count of 0
+	secrets, err := cli.SecretList(ctx, types.SecretListOptions{})
+	if err != nil {
+		logger.Error("Error listing secrets", zap.Error(err))
+	}
+	for _, secret := range secrets {
+		secretServices.WithLabelValues(secret.Spec.Name).Set(float64(secretRefs[secret.Spec.Name]))
+	}
+	configs, err := cli.ConfigList(ctx, types.ConfigListOptions{})
+	if err != nil {
+		logger.Error("Error listing configs", zap.Error(err))
+	}
+	for _, config := range configs {
+		configServices.WithLabelValues(config.Spec.Name).Set(float64(configRefs[config.Spec.Name]))
+	}
+}
+
+func setOverlayNetworkMetrics(ctx context.Context, cli *client.Client, services []swarm.Service) {
+	networks, err := cli.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(filters.Arg("driver", "overlay"), filters.Arg("scope", "swarm"))})
+	if err != nil {
+		logger.Error("Error listing overlay networks", zap.Error(err))
+		return
+	}
+	tasks, err := cli.TaskList(ctx, types.TaskListOptions{})
+	if err != nil {
+		logger.Error("Error listing tasks", zap.Error(err))
+		return
+	}
+
+	// Addresses allocated per network, from service VIPs and task attachments
+	addresses := map[string][]netip.Prefix{}
+	add := func(networkID, addr string) {
+		if prefix, err := netip.ParsePrefix(addr); err == nil {
+			addresses[networkID] = append(addresses[networkID], prefix)
+		}
+	}
+	for _, service := range services {
+		for _, vip := range service.Endpoint.VirtualIPs {
+			add(vip.NetworkID, vip.Addr)
+		}
+	}
+
+	// Every node with a task on the network also gets a load balancer address
+	nodes := map[string]map[string]bool{}
+	for _, task := range tasks {
+		// Addresses of finished tasks are released
+		if taskTerminated(task.Status.State) {
+			continue
+		}
+		for _, attachment := range task.NetworksAttachments {
+			for _, addr := range attachment.Addresses {
+				add(attachment.Network.ID, addr)
+			}
+			if nodes[attachment.Network.ID] == nil {
+				nodes[attachment.Network.ID] = map[string]bool{}
+			}
+			nodes[attachment.Network.ID][task.NodeID] = true
+		}
+	}
+
+	for _, nw := range networks {
+		for i, config := range nw.IPAM.Config {
+			subnet, err := netip.ParsePrefix(config.Subnet)
+			if err != nil {
+				continue
+			}
+
+			allocated := 0
+			for _, addr := range addresses[nw.ID] {
+				if subnet.Contains(addr.Addr()) {
+					allocated++
+				}
+			}
+			// Load balancer addresses come from the first subnet
+			if i == 0 {
+				allocated += len(nodes[nw.ID])
+			}
+
+			// The network, broadcast and gateway addresses can't be allocated
+			size := math.Pow(2, float64(subnet.Addr().BitLen()-subnet.Bits())) - 3
+			networkSubnetSize.WithLabelValues(nw.Name, config.Subnet).Set(max(size, 0))
+			networkAllocatedIPs.WithLabelValues(nw.Name, config.Subnet).Set(float64(allocated))
+		}
+	}
+}
+
+func taskTerminated(state swarm.TaskState) bool {
+	switch state {
+	case swarm.TaskStateComplete, swarm.TaskStateShutdown, swarm.TaskStateFailed,
+		swarm.TaskStateRejected, swarm.TaskStateRemove, swarm.TaskStateOrphaned:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const (
+	tenantLabel = "tenant"
+)
+
+var (
+	tenantRulesFlag = flag.String("tenantRules", "", "Comma separated rules mapping containers to tenants by container label, first match wins: label=value:tenant, or label alone to use its value as the tenant")
+	defaultTenant   = flag.String("defaultTenant", "", "Tenant of containers no tenant rule matched, their series get no tenant label when empty")
+	tenantEndpoints = flag.Bool("tenantEndpoints", false, "Serve /tenants/<tenant>/metrics with only the series of the containers of that tenant")
+
+	tenantRules []tenantRule
+
+	// Tenant by container name, refreshed every collection
+	tenantsMu sync.RWMutex
+	tenants   = map[string]string{}
+)
+
+// tenantRule maps containers with a label to a tenant
+type tenantRule struct {
+	label string
+	// Matches any value when empty
+	value string
+	// The value of the label is the tenant when empty
+	tenant string
+}
+
+func parseTenantRules(list string) ([]tenantRule, error) {
+	var rules []tenantRule
+	for _, item := range splitList(list) {
+		match, tenant, hasTenant := strings.Cut(item, ":")
+		label, value, hasValue := strings.Cut(match, "=")
+		if label == "" || (hasValue && value == "") || (hasTenant && tenant == "") {
+			return nil, fmt.Errorf("invalid tenant rule %q", item)
+		}
+		if hasValue && !hasTenant {
+			return nil, fmt.Errorf("tenant rule %q matches a value but names no tenant", item)
+		}
+		rules = append(rules, tenantRule{label: label, value: value, tenant: tenant})
+	}
+	return rules, nil
+}
+
+func containerTenant(labels map[string]string) string {
+	for _, rule := range tenantRules {
+		value, ok := labels[rule.label]
+		if !ok || (rule.value != "" && value != rule.value) {
+			continue
+		}
+		if rule.tenant != "" {
+			return rule.tenant
+		}
+		if value != "" {
+			return value
+		}
+	}
+	return *defaultTenant
+}
+
+func refreshTenants(ctx context.Context, cli *client.Client) {
+	if len(tenantRules) == 0 {
+		return
+	}
+	// Stopped containers too, their exits and runs are reported
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{All: true})
+	if err != nil {
+		logger.Error("Error listing containers for tenants", zap.Error(err))
+		return
+	}
+	refreshed := make(map[string]string, len(containers))
+	for _, container := range containers {
+		if tenant := containerTenant(container.Labels); tenant != "" {
+			refreshed[container.Names[0]] = tenant
+		}
+	}
+
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	tenants = refreshed
+}
+
+func addTenantLabels(families []*dto.MetricFamily) {
+	if len(tenantRules) == 0 {
+		return
+	}
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+
+	// Only container series belong to a tenant, host and exporter series don't
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			containerName, tenant := "", ""
+			for _, label := range m.GetLabel() {
+				switch label.GetName() {
+				case "container_name":
+					containerName = label.GetValue()
+				case tenantLabel:
+					tenant = label.GetValue()
+				}
+			}
+			if containerName == "" || tenant != "" {
+				continue
+			}
+			if tenant = tenants[containerName]; tenant == "" {
+				continue
+			}
+			name := tenantLabel
+			m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &tenant})
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+}
+
+func tenantGatherer(gatherer prometheus.Gatherer, tenant string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		var filtered []*dto.MetricFamily
+		for _, mf := range families {
+			var metrics []*dto.Metric
+			for _, m := range mf.GetMetric() {
+				if metricTenant(m) == tenant {
+					metrics = append(metrics, m)
+				}
+			}
+			if len(metrics) == 0 {
+				continue
+			}
+			// The families are shared with other scrapes
+			filtered = append(filtered, &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Unit: mf.Unit, Metric: metrics})
+		}
+		return filtered, err
+	})
+}
+
+func metricTenant(m *dto.Metric) string {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == tenantLabel {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func newTenantMetricsHandler(gatherer prometheus.Gatherer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/tenants/"), "/metrics")
+		if !ok || tenant == "" || strings.Contains(tenant, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		// With tenant tokens, teams only get to scrape their own tenant
+		if tenantTokens != nil {
+			scraper, all, ok := scrapeTenant(r)
+			if !ok || (!all && scraper != tenant) {
+				logger.Warn("Rejected unauthorized scrape", zap.String("path", r.URL.Path), zap.String("remote", r.RemoteAddr))
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		promhttp.HandlerFor(tenantGatherer(gatherer, tenant), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
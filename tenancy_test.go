@@ -0,0 +1,269 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseTenantRules(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []tenantRule
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "team", want: []tenantRule{{label: "team"}}},
+		{list: "team:shared", want: []tenantRule{{label: "team", tenant: "shared"}}},
+		{list: "env=prod:ops, team", want: []tenantRule{{label: "env", value: "prod", tenant: "ops"}, {label: "team"}}},
+		{list: "env=prod", wantErr: true},
+		{list: "env=:ops", wantErr: true},
+		{list: "team:", wantErr: true},
+		{list: "=prod:ops", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			rules, err := parseTenantRules(tt.list)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got rules %v, want an error", rules)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("got rules %v, want %v", rules, tt.want)
+			}
+			for i := range rules {
+				if rules[i] != tt.want[i] {
+					t.Errorf("rule %d is %+v, want %+v", i, rules[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestContainerTenant(t *testing.T) {
+	defer setTenantConfig(t, "env=prod:ops,team", "fallback")()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{name: "value match", labels: map[string]string{"env": "prod", "team": "web"}, want: "ops"},
+		{name: "label value", labels: map[string]string{"env": "dev", "team": "web"}, want: "web"},
+		{name: "empty label value", labels: map[string]string{"team": ""}, want: "fallback"},
+		{name: "no match", labels: map[string]string{"app": "web"}, want: "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containerTenant(tt.labels); got != tt.want {
+				t.Errorf("got tenant %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantFiltering(t *testing.T) {
+	defer setTenantConfig(t, "team", "")()
+	tenantsMu.Lock()
+	tenants = map[string]string{"/web": "web", "/db": "data"}
+	tenantsMu.Unlock()
+
+	families := func() []*dto.MetricFamily {
+		return []*dto.MetricFamily{
+			testFamily("docker_container_memory_usage_bytes",
+				map[string]string{"container_name": "/web"},
+				map[string]string{"container_name": "/db"},
+				map[string]string{"container_name": "/untenanted"},
+			),
+			testFamily("docker_container_exits_total",
+				map[string]string{"container_name": "/db", "tenant": "web"},
+			),
+			testFamily("docker_prom_build_info", map[string]string{"version": "dev"}),
+		}
+	}
+
+	tests := []struct {
+		tenant string
+		want   []string
+	}{
+		{
+			tenant: "web",
+			want: []string{
+				`docker_container_memory_usage_bytes{container_name="/web",tenant="web"}`,
+				// An explicit tenant label is kept
+				`docker_container_exits_total{container_name="/db",tenant="web"}`,
+			},
+		},
+		{
+			tenant: "data",
+			want:   []string{`docker_container_memory_usage_bytes{container_name="/db",tenant="data"}`},
+		},
+		{
+			tenant: "unknown",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				gathered := families()
+				addTenantLabels(gathered)
+				return gathered, nil
+			})
+			filtered, err := tenantGatherer(gatherer, tt.tenant).Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, mf := range filtered {
+				for _, m := range mf.Metric {
+					got = append(got, seriesName(mf.GetName(), m))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got series\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadTenantTokens(t *testing.T) {
+	defer func(saved map[string]string) { tenantTokens = saved }(tenantTokens)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			config: "web: [a, b]\ndata: [c]\n",
+			want:   map[string]string{"a": "web", "b": "web", "c": "data"},
+		},
+		{
+			name:    "empty token",
+			config:  "web: ['']\n",
+			wantErr: "empty token",
+		},
+		{
+			name:    "shared token",
+			config:  "web: [a]\ndata: [a]\n",
+			wantErr: "token shared by tenants",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			err := loadTenantTokens(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tenantTokens) != len(tt.want) {
+				t.Fatalf("got tokens %v, want %v", tenantTokens, tt.want)
+			}
+			for token, tenant := range tt.want {
+				if tenantTokens[token] != tenant {
+					t.Errorf("token %s is of tenant %q, want %q", token, tenantTokens[token], tenant)
+				}
+			}
+		})
+	}
+}
+
+func TestTenantTokenAccess(t *testing.T) {
+	logger = zap.NewNop()
+	defer func(saved map[string]string, savedAdmin, savedFile string) {
+		tenantTokens, *adminToken, *tenantTokensFile = saved, savedAdmin, savedFile
+	}(tenantTokens, *adminToken, *tenantTokensFile)
+	tenantTokens = map[string]string{"web-token": "web"}
+	*adminToken = "admin-token"
+	*tenantTokensFile = "tokens.yaml"
+
+	tests := []struct {
+		name       string
+		header     string
+		tenant     string
+		all, ok    bool
+		adminAllow int
+	}{
+		{name: "no token", header: "", adminAllow: http.StatusUnauthorized},
+		{name: "not bearer", header: "Basic web-token", adminAllow: http.StatusUnauthorized},
+		{name: "unknown token", header: "Bearer guess", adminAllow: http.StatusUnauthorized},
+		{name: "tenant token", header: "Bearer web-token", tenant: "web", ok: true, adminAllow: http.StatusForbidden},
+		{name: "admin token", header: "Bearer admin-token", all: true, ok: true, adminAllow: http.StatusOK},
+	}
+
+	handler := adminOnlyWithTenants(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/prune-plan", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			tenant, all, ok := scrapeTenant(r)
+			if tenant != tt.tenant || all != tt.all || ok != tt.ok {
+				t.Errorf("got tenant %q, all %t, ok %t, want %q, %t, %t", tenant, all, ok, tt.tenant, tt.all, tt.ok)
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.adminAllow {
+				t.Errorf("admin only endpoint answered %d, want %d", w.Code, tt.adminAllow)
+			}
+		})
+	}
+}
+
+// setTenantConfig sets the tenant rules and default tenant, the returned
+// function restores the previous ones
+func setTenantConfig(t *testing.T, rules, fallback string) func() {
+	t.Helper()
+	savedRules, savedDefault := tenantRules, *defaultTenant
+	parsed, err := parseTenantRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantRules, *defaultTenant = parsed, fallback
+	return func() {
+		tenantRules, *defaultTenant = savedRules, savedDefault
+	}
+}
+
+func testFamily(name string, series ...map[string]string) *dto.MetricFamily {
+	family := &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum()}
+	for _, labels := range series {
+		m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(1)}}
+		for labelName, value := range labels {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(labelName), Value: proto.String(value)})
+		}
+		family.Metric = append(family.Metric, m)
+	}
+	return family
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	tenantTokensFile = flag.String("tenantTokensFile", "", "YAML file of bearer tokens per tenant; when set, scrapes need a token and only see the series of its tenant; the admin token sees all and is required by the status and plan endpoints")
+
+	// Bearer token -> tenant
+	tenantTokens map[string]string
+)
+
+func loadTenantTokens(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading tenant tokens: %w", err)
+	}
+	byTenant := map[string][]string{}
+	if err := yaml.Unmarshal(data, &byTenant); err != nil {
+		return fmt.Errorf("error parsing tenant tokens %s: %w", path, err)
+	}
+	tokens := map[string]string{}
+	for tenant, list := range byTenant {
+		for _, token := range list {
+			if token == "" {
+				return fmt.Errorf("empty token for tenant %s", tenant)
+			}
+			if other, ok := tokens[token]; ok && other != tenant {
+				return fmt.Errorf("token shared by tenants %s and %s", other, tenant)
+			}
+			tokens[token] = tenant
+		}
+	}
+	tenantTokens = tokens
+	return nil
+}
+
+// scrapeTenant returns the tenant of the request's token, all is true for the
+// admin token
+func scrapeTenant(r *http.Request) (tenant string, all, ok bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", false, false
+	}
+	if *adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1 {
+		return "", true, true
+	}
+	// Compare against every token, so timing doesn't tell how close a guess was
+	for candidate, t := range tenantTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			tenant, ok = t, true
+		}
+	}
+	return tenant, false, ok
+}
+
+func newTenantScrapeHandler(gatherer prometheus.Gatherer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, all, ok := scrapeTenant(r)
+		if !ok {
+			logger.Warn("Rejected unauthorized scrape", zap.String("path", r.URL.Path), zap.String("remote", r.RemoteAddr))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if all {
+			promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+			return
+		}
+		promhttp.HandlerFor(tenantGatherer(gatherer, tenant), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// adminOnlyWithTenants keeps endpoints listing the objects of every tenant to
+// the admin token once scrapes are split by tenant
+func adminOnlyWithTenants(next http.HandlerFunc) http.HandlerFunc {
+	if *tenantTokensFile == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, all, ok := scrapeTenant(r)
+		switch {
+		case !ok:
+			logger.Warn("Rejected unauthorized request", zap.String("path", r.URL.Path), zap.String("remote", r.RemoteAddr))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		case !all:
+			logger.Warn("Rejected tenant request for an endpoint of all tenants", zap.String("path", r.URL.Path), zap.String("remote", r.RemoteAddr))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	tenantCPUSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_tenant_cpu_seconds_total",
+			Help: "CPU time used by the containers of the tenant",
+		},
+		[]string{tenantLabel},
+	)
+	tenantMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_tenant_memory_bytes",
+			Help: "Memory used by the running containers of the tenant, without reclaimable page cache",
+		},
+		[]string{tenantLabel},
+	)
+	tenantContainers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_tenant_containers",
+			Help: "Number of running containers of the tenant",
+		},
+		[]string{tenantLabel},
+	)
+
+	// CPU usage in nanoseconds at the last collection, by container ID
+	tenantUsageMu sync.Mutex
+	tenantLastCPU = map[string]uint64{}
+)
+
+func init() {
+	c := registerCollector("tenants", collectTenantMetrics, tenantCPUSeconds, tenantMemory, tenantContainers)
+	c.primaryOnly = true
+	c.dumpState = func() map[string]any {
+		tenantUsageMu.Lock()
+		defer tenantUsageMu.Unlock()
+		return map[string]any{"trackedContainers": len(tenantLastCPU)}
+	}
+}
+
+func collectTenantMetrics(ctx context.Context, cli *client.Client) {
+	if len(tenantRules) == 0 && *defaultTenant == "" {
+		logger.Debug("No tenant rules, skipping tenant usage")
+		return
+	}
+	containers, err := listContainers(ctx, cli, typeContainer.ListOptions{})
+	if err != nil {
+		logger.Error("Error listing containers", zap.Error(err))
+		return
+	}
+
+	// Clear old metrics to avoid duplicates
+	tenantMemory.Reset()
+	tenantContainers.Reset()
+
+	tenantUsageMu.Lock()
+	defer tenantUsageMu.Unlock()
+
+	seen := make(map[string]uint64, len(containers))
+	for _, container := range containers {
+		tenant := containerTenant(container.Labels)
+		if tenant == "" {
+			continue
+		}
+		containerName := container.Names[0]
+		tenantContainers.WithLabelValues(tenant).Inc()
+
+		stats, err := containerStats(ctx, cli, container.ID)
+		if err != nil {
+			logger.Error("Error getting stats for container", zap.String("containerName", containerName), zap.Error(err))
+			setCollectionError(containerName, stageStats, err)
+			// Keep the last usage, it would be counted again otherwise
+			if last, ok := tenantLastCPU[container.ID]; ok {
+				seen[container.ID] = last
+			}
+			continue
+		}
+		tenantMemory.WithLabelValues(tenant).Add(float64(memoryUsage(stats)))
+
+		// The counter grows by what each container used since the last
+		// collection, a restarted container starts over from zero
+		usage := stats.CPUStats.CPUUsage.TotalUsage
+		delta := usage
+		if last, ok := tenantLastCPU[container.ID]; ok && usage >= last {
+			delta = usage - last
+		}
+		tenantCPUSeconds.WithLabelValues(tenant).Add(float64(delta) / 1e9)
+		seen[container.ID] = usage
+	}
+	tenantLastCPU = seen
+}
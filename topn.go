@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	statsTopN = flag.Int("statsTopN", 0, "Only export per container stats of the top N containers by CPU and by memory, plus totals over all containers (0 exports all)")
+
+	containerTopCPU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_top_cpu_cores",
+			Help: "CPU used since the last collection in cores, by the containers using the most",
+		},
+		[]string{"container_name", "rank"},
+	)
+	containerTopMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_top_memory_bytes",
+			Help: "Memory used without reclaimable page cache, by the containers using the most",
+		},
+		[]string{"container_name", "rank"},
+	)
+	containersCPU = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_containers_cpu_cores",
+			Help: "CPU used since the last collection in cores, by all running containers",
+		},
+	)
+	containersMemory = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docker_containers_memory_bytes",
+			Help: "Memory used without reclaimable page cache, by all running containers",
+		},
+	)
+
+	topGauges = []*prometheus.GaugeVec{containerTopCPU, containerTopMemory}
+)
+
+func topContainers(usages []containerUsage) map[string]bool {
+	// Totals are cheap, they are kept in either mode
+	var totalCores float64
+	var totalMemory uint64
+	for _, usage := range usages {
+		totalCores += usage.cores
+		totalMemory += usage.memory
+	}
+	containersCPU.Set(totalCores)
+	containersMemory.Set(float64(totalMemory))
+
+	if *statsTopN <= 0 {
+		return nil
+	}
+
+	// Clear old metrics to avoid duplicates
+	for _, g := range topGauges {
+		g.Reset()
+	}
+
+	// Ranked on a copy, the caller keeps the container order
+	usages = slices.Clone(usages)
+	top := map[string]bool{}
+	rank := func(gauge *prometheus.GaugeVec, value func(containerUsage) float64) {
+		sort.SliceStable(usages, func(i, j int) bool {
+			return value(usages[i]) > value(usages[j])
+		})
+		for i, usage := range usages[:min(*statsTopN, len(usages))] {
+			gauge.WithLabelValues(usage.name, strconv.Itoa(i+1)).Set(value(usage))
+			top[usage.id] = true
+		}
+	}
+	rank(containerTopCPU, func(u containerUsage) float64 { return u.cores })
+	rank(containerTopMemory, func(u containerUsage) float64 { return float64(u.memory) })
+	return top
+}
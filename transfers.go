@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Image archives written or read by the daemon, large enough to show up
+	// as disk and IO spikes
+	imageTransfers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_image_transfers_total",
+			Help: "Number of image save, load and import operations and container exports, by operation",
+		},
+		[]string{"operation"},
+	)
+	imageTransferLast = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_image_transfer_last_timestamp_seconds",
+			Help: "Time of the last image save, load or import or container export, by operation",
+		},
+		[]string{"operation"},
+	)
+)
+
+func init() {
+	c := registerCollector("transfers", nil, imageTransfers, imageTransferLast)
+	c.handleEvent = handleTransferEvent
+}
+
+func handleTransferEvent(msg events.Message) {
+	// Save and load report an event per image of the archive, export is a
+	// container event
+	switch {
+	case msg.Type == events.ImageEventType && (msg.Action == events.ActionSave || msg.Action == events.ActionLoad || msg.Action == events.ActionImport):
+	case msg.Type == events.ContainerEventType && msg.Action == events.ActionExport:
+	default:
+		return
+	}
+	operation := string(msg.Action)
+	imageTransfers.WithLabelValues(operation).Inc()
+	imageTransferLast.WithLabelValues(operation).Set(float64(msg.TimeNano) / float64(time.Second))
+}
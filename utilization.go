@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	typeContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// What a utilization ratio is relative to
+	basisLimit = "limit"
+	basisHost  = "host"
+
+	// CFS period in microseconds when not configured
+	defaultCPUPeriod = 100000
+)
+
+var (
+	utilization = flag.Bool("utilization", false, "Export CPU and memory utilization of containers relative to their limits, or to the host capacity when unlimited (inspects every container)")
+
+	containerMemoryUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_memory_utilization_ratio",
+			Help: "Memory used without reclaimable page cache, relative to the memory limit or to the host memory",
+		},
+		[]string{"container_name", "basis"},
+	)
+	containerCPUUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docker_container_cpu_utilization_ratio",
+			Help: "CPU used since the last collection, relative to the CPU limit or to the host CPUs",
+		},
+		[]string{"container_name", "basis"},
+	)
+
+	utilizationGauges = []*prometheus.GaugeVec{containerMemoryUtilization, containerCPUUtilization}
+)
+
+func setUtilizationMetrics(ctx context.Context, cli *client.Client, usages []containerUsage) {
+	if !*utilization {
+		return
+	}
+
+	// Clear old metrics to avoid duplicates
+	for _, g := range utilizationGauges {
+		g.Reset()
+	}
+
+	info, err := cli.Info(ctx)
+	if err != nil {
+		logger.Error("Error getting Docker info", zap.Error(err))
+		return
+	}
+
+	for _, usage := range usages {
+		inspect, err := cli.ContainerInspect(ctx, usage.id)
+		if err != nil {
+			logger.Error("Error inspecting container", zap.String("containerName", usage.name), zap.Error(err))
+			setCollectionError(usage.name, stageInspect, err)
+			continue
+		}
+		if inspect.HostConfig == nil {
+			continue
+		}
+
+		memoryLimit, basis := float64(inspect.HostConfig.Memory), basisLimit
+		if memoryLimit <= 0 {
+			memoryLimit, basis = float64(info.MemTotal), basisHost
+		}
+		if memoryLimit > 0 {
+			containerMemoryUtilization.WithLabelValues(usage.name, basis).Set(float64(usage.memory) / memoryLimit)
+		}
+
+		cpuLimit, basis := cpuQuotaCores(inspect.HostConfig), basisLimit
+		if cpuLimit <= 0 {
+			cpuLimit, basis = float64(info.NCPU), basisHost
+		}
+		if cpuLimit > 0 {
+			containerCPUUtilization.WithLabelValues(usage.name, basis).Set(usage.cores / cpuLimit)
+		}
+	}
+}
+
+func cpuQuotaCores(hostConfig *typeContainer.HostConfig) float64 {
+	// --cpus sets NanoCPUs, --cpu-quota the CFS quota per period of 100ms
+	// unless --cpu-period says otherwise
+	if hostConfig.NanoCPUs > 0 {
+		return float64(hostConfig.NanoCPUs) / 1e9
+	}
+	if hostConfig.CPUQuota <= 0 {
+		return 0
+	}
+	period := hostConfig.CPUPeriod
+	if period <= 0 {
+		period = defaultCPUPeriod
+	}
+	return float64(hostConfig.CPUQuota) / float64(period)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+)
+
+var (
+	warmupConcurrency = flag.Int("warmupConcurrency", 8, "Number of concurrent registry lookups during the first collection, later collections do them one at a time")
+
+	// Closed once the first collection finished
+	warmedUp = make(chan struct{})
+)
+
+func warmupDone() bool {
+	select {
+	case <-warmedUp:
+		return true
+	default:
+		return false
+	}
+}
+
+func registryConcurrency() int {
+	// Burst at startup so freshly booted hosts report a full set of metrics
+	// quickly, then spread the lookups out to go easy on the registries
+	if warmupDone() {
+		return 1
+	}
+	return max(*warmupConcurrency, 1)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Trapper port of the Zabbix server or proxy
+	defaultZabbixPort = "10051"
+	zabbixTimeout     = 10 * time.Second
+	// Items per sender request, the server refuses very large ones
+	zabbixBatchSize = 1000
+	// Largest response read from the server, it's a short status line
+	maxZabbixResponseSize = 1 << 20
+)
+
+var (
+	zabbixServer  = flag.String("zabbixServer", "", "Zabbix server or proxy (host[:port]) the metrics are sent to after each collection with the sender protocol, to trapper items")
+	zabbixHost    = flag.String("zabbixHost", "", "Host name of the items in Zabbix (default the host name)")
+	zabbixKeysMap = flag.String("zabbixKeys", "", "YAML file mapping metric names to Zabbix item keys, with {label} placeholders; only mapped metrics are sent (default all metrics, as name[label values])")
+
+	zabbixItems = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docker_prom_zabbix_items_total",
+			Help: "Number of items sent to Zabbix by whether the server processed them",
+		},
+		[]string{"outcome"},
+	)
+	zabbixErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "docker_prom_zabbix_errors_total",
+			Help: "Number of sender requests to Zabbix that failed",
+		},
+	)
+
+	zabbixHeader      = []byte("ZBXD\x01")
+	zabbixPlaceholder = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+	zabbixInfo        = regexp.MustCompile(`processed: (\d+); failed: (\d+)`)
+)
+
+func init() {
+	exporterRegistry.MustRegister(zabbixItems, zabbixErrors)
+}
+
+// zabbixKey maps a metric to the key of its trapper item
+type zabbixKey struct {
+	Metric string `yaml:"metric"`
+	// Item key, e.g. docker.container.memory[{container_name}]
+	Key string `yaml:"key"`
+}
+
+// zabbixItem is a value of the sender protocol
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// zabbixSender sends the metrics of a gatherer to Zabbix after each collection
+type zabbixSender struct {
+	server   string
+	host     string
+	keys     map[string]string
+	gatherer prometheus.Gatherer
+}
+
+func newZabbixSender(gatherer prometheus.Gatherer) (*zabbixSender, error) {
+	s := &zabbixSender{server: *zabbixServer, host: *zabbixHost, gatherer: gatherer}
+	if _, _, err := net.SplitHostPort(s.server); err != nil {
+		s.server = net.JoinHostPort(s.server, defaultZabbixPort)
+	}
+	if s.host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting host name: %w", err)
+		}
+		s.host = hostname
+	}
+	if *zabbixKeysMap != "" {
+		keys, err := loadZabbixKeys(*zabbixKeysMap)
+		if err != nil {
+			return nil, err
+		}
+		s.keys = keys
+	}
+	return s, nil
+}
+
+func loadZabbixKeys(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Zabbix keys: %w", err)
+	}
+	var mappings []zabbixKey
+	if err := yaml.Unmarshal(data, &mappings); err != nil {
+		return nil, fmt.Errorf("error parsing Zabbix keys %s: %w", path, err)
+	}
+	keys := map[string]string{}
+	for _, m := range mappings {
+		if m.Metric == "" || m.Key == "" {
+			return nil, fmt.Errorf("Zabbix keys need a metric and a key")
+		}
+		keys[m.Metric] = m.Key
+	}
+	return keys, nil
+}
+
+func (s *zabbixSender) run(sends <-chan time.Time) {
+	// Sends happen off the collection loop like file writes, a slow server
+	// only delays Zabbix
+	for collectedAt := range sends {
+		if err := s.send(collectedAt); err != nil {
+			zabbixErrors.Inc()
+			logger.Error("Error sending metrics to Zabbix", zap.String("server", s.server), zap.Error(err))
+		}
+	}
+}
+
+func (s *zabbixSender) send(collectedAt time.Time) error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+	items := s.items(families, collectedAt.Unix())
+	for len(items) > 0 {
+		batch := items[:min(len(items), zabbixBatchSize)]
+		items = items[len(batch):]
+		if err := s.sendBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *zabbixSender) items(families []*dto.MetricFamily, clock int64) []zabbixItem {
+	var items []zabbixItem
+	add := func(name string, m *dto.Metric, value float64) {
+		key, ok := s.itemKey(name, m)
+		if !ok {
+			return
+		}
+		items = append(items, zabbixItem{Host: s.host, Key: key, Value: strconv.FormatFloat(value, 'g', -1, 64), Clock: clock})
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				add(name, m, m.GetGauge().GetValue())
+			case dto.MetricType_COUNTER:
+				add(name, m, m.GetCounter().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m, m.GetUntyped().GetValue())
+			// Buckets and quantiles would need an item each, Zabbix
+			// computes its own from the sum and count
+			case dto.MetricType_HISTOGRAM:
+				add(name+"_sum", m, m.GetHistogram().GetSampleSum())
+				add(name+"_count", m, float64(m.GetHistogram().GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				add(name+"_sum", m, m.GetSummary().GetSampleSum())
+				add(name+"_count", m, float64(m.GetSummary().GetSampleCount()))
+			}
+		}
+	}
+	return items
+}
+
+func (s *zabbixSender) itemKey(name string, m *dto.Metric) (string, bool) {
+	if s.keys == nil {
+		if len(m.GetLabel()) == 0 {
+			return name, true
+		}
+		var params []string
+		for _, label := range m.GetLabel() {
+			params = append(params, zabbixParam(label.GetValue()))
+		}
+		return name + "[" + strings.Join(params, ",") + "]", true
+	}
+
+	key, ok := s.keys[name]
+	if !ok {
+		return "", false
+	}
+	return zabbixPlaceholder.ReplaceAllStringFunc(key, func(placeholder string) string {
+		labelName := placeholder[1 : len(placeholder)-1]
+		for _, label := range m.GetLabel() {
+			if label.GetName() == labelName {
+				return zabbixParam(label.GetValue())
+			}
+		}
+		return `""`
+	}), true
+}
+
+func zabbixParam(value string) string {
+	// Parameters with separators are quoted, quotes escaped
+	if value == "" || strings.ContainsAny(value, `,[]" `) {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
+
+func (s *zabbixSender) sendBatch(items []zabbixItem) error {
+	body, err := json.Marshal(map[string]any{"request": "sender data", "data": items, "clock": time.Now().Unix()})
+	if err != nil {
+		return fmt.Errorf("error encoding sender request: %w", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", s.server, zabbixTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(zabbixTimeout)); err != nil {
+		return err
+	}
+
+	// Header, then the data length as 4 bytes little endian and 4 reserved
+	var request bytes.Buffer
+	request.Write(zabbixHeader)
+	binary.Write(&request, binary.LittleEndian, uint32(len(body)))
+	binary.Write(&request, binary.LittleEndian, uint32(0))
+	request.Write(body)
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return fmt.Errorf("error sending items: %w", err)
+	}
+
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return fmt.Errorf("unexpected response header %q", header[:len(zabbixHeader)])
+	}
+	size := binary.LittleEndian.Uint32(header[len(zabbixHeader):])
+	if size > maxZabbixResponseSize {
+		return fmt.Errorf("response of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	var response struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	if response.Response != "success" {
+		return fmt.Errorf("server answered %q: %s", response.Response, response.Info)
+	}
+
+	// Items without a trapper item of that key on the host fail one by one
+	if match := zabbixInfo.FindStringSubmatch(response.Info); match != nil {
+		processed, _ := strconv.Atoi(match[1])
+		failed, _ := strconv.Atoi(match[2])
+		zabbixItems.WithLabelValues("processed").Add(float64(processed))
+		zabbixItems.WithLabelValues("failed").Add(float64(failed))
+		if failed > 0 {
+			logger.Debug("Zabbix failed some items, they may have no trapper item", zap.Int("failed", failed), zap.String("info", response.Info))
+		}
+	}
+	return nil
+}
+
+func requestZabbixSend(sends chan time.Time, collectedAt time.Time) {
+	// A pending send is replaced by the newer snapshot
+	select {
+	case <-sends:
+		logger.Warn("Zabbix send still running, skipping a cycle")
+	default:
+	}
+	sends <- collectedAt
+}